@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// copyAlternateStreams is a no-op outside Windows, where files have no
+// alternate data streams
+func copyAlternateStreams(sourceFile, destFile string) error {
+	return nil
+}
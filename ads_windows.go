@@ -0,0 +1,95 @@
+//go:build windows
+
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32          = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStreamW = modkernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modkernel32.NewProc("FindNextStreamW")
+)
+
+// findStreamInfoStandard is the FindStreamInfoStandard info level
+const findStreamInfoStandard = 0
+
+// win32FindStreamData mirrors the WIN32_FIND_STREAM_DATA structure
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+// alternateStreams lists the names of the alternate data streams of a file,
+// e.g. "Zone.Identifier" for ":Zone.Identifier:$DATA"
+func alternateStreams(path string) ([]string, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data win32FindStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), findStreamInfoStandard, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		if err == syscall.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.FindClose(syscall.Handle(handle))
+
+	var streams []string
+	for {
+		name := syscall.UTF16ToString(data.StreamName[:])
+		// "::$DATA" is the unnamed main stream, which CopyFile already copied
+		if name != "::$DATA" && strings.HasSuffix(name, ":$DATA") {
+			streams = append(streams, strings.TrimSuffix(strings.TrimPrefix(name, ":"), ":$DATA"))
+		}
+
+		ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if err == syscall.ERROR_HANDLE_EOF {
+				break
+			}
+			return streams, err
+		}
+	}
+	return streams, nil
+}
+
+// copyAlternateStreams copies every alternate data stream of sourceFile to destFile
+func copyAlternateStreams(sourceFile, destFile string) error {
+	streams, err := alternateStreams(sourceFile)
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		if err := copyStream(sourceFile+":"+stream, destFile+":"+stream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyStream(sourceStream, destStream string) error {
+	source, err := os.Open(sourceStream)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(destStream)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	_, err = io.Copy(destination, source)
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Values accepted by the anomaly_check option
+const (
+	anomalyOff   = "off" // default
+	anomalyWarn  = "warn"
+	anomalyAbort = "abort" // refuse to mirror an unusual run
+)
+
+// defaultAnomalyFactor is how many times the average a run has to change
+// to be unusual
+const defaultAnomalyFactor = 10
+
+const (
+	runHistory        = 30  // runs kept in the state DB to average
+	minAnomalyHistory = 5   // runs to know before judging one
+	minAnomalyChanges = 100 // changes that are never unusual, however few the average
+)
+
+// RunRecord is what a run planned to change, kept in the state DB to tell
+// normal runs from unusual ones
+type RunRecord struct {
+	Start   time.Time `json:"start"`
+	Changed int64     `json:"changed"` // files to copy
+	Deleted int64     `json:"deleted"` // synced files gone from the source
+}
+
+// recordRun adds run to the history, dropping the oldest runs
+func (s *State) recordRun(run RunRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Runs = append(s.Runs, run)
+	if len(s.Runs) > runHistory {
+		s.Runs = s.Runs[len(s.Runs)-runHistory:]
+	}
+}
+
+// plannedRun describes what the scanned run is about to change
+func (s *State) plannedRun(start time.Time, scan *scanResult) RunRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := RunRecord{Start: start, Changed: scan.Files}
+	for name := range s.Files {
+		if !scan.inSource(name) {
+			run.Deleted++
+		}
+	}
+	return run
+}
+
+// checkAnomaly compares run with the average of the previous runs, to stop
+// before mirroring the changes of a ransomware, or the deletions of a
+// source that is not mounted
+func checkAnomaly(state *State, run RunRecord, config Config) error {
+	if config.AnomalyCheck == anomalyOff || len(state.Runs) < minAnomalyHistory {
+		return nil
+	}
+	var changed, deleted float64
+	for _, previous := range state.Runs {
+		changed += float64(previous.Changed) / float64(len(state.Runs))
+		deleted += float64(previous.Deleted) / float64(len(state.Runs))
+	}
+
+	var err error
+	unusual := func(count int64, average float64) bool {
+		return count >= minAnomalyChanges && float64(count) > average*config.AnomalyFactor
+	}
+	switch {
+	case unusual(run.Changed, changed):
+		err = fmt.Errorf("%d files to copy, against %.0f on average over the last %d runs", run.Changed, changed, len(state.Runs))
+	case unusual(run.Deleted, deleted):
+		err = fmt.Errorf("%d files deleted at the source, against %.0f on average over the last %d runs", run.Deleted, deleted, len(state.Runs))
+	default:
+		return nil
+	}
+
+	if config.AnomalyCheck == anomalyWarn {
+		errorf("Warning: unusual run: %v\n", err)
+		return nil
+	}
+	return fmt.Errorf("unusual run, not synced: %v; set anomaly_check to warn to sync anyway", err)
+}
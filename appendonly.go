@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// versionName is the name under which the run started at start stores the
+// new version of name with append_only, e.g. "a/report~2006-01-02_150405.txt"
+func versionName(name string, start time.Time) string {
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if ext == base {
+		ext = "" // dot files have no extension
+	}
+	return dir + strings.TrimSuffix(base, ext) + "~" + start.Format(backupRunFormat) + ext
+}
+
+// versionOf is the destination name of the latest version of name, which
+// is name itself until append_only stored a new version
+func (s *State) versionOf(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version, ok := s.Versions[name]; ok {
+		return version
+	}
+	return name
+}
+
+// setVersion makes version the latest version of name, returning the one
+// it replaces to undo it with if the copy fails
+func (s *State) setVersion(name, version string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.Versions[name]
+	if !ok {
+		previous = name
+	}
+	if version == name {
+		delete(s.Versions, name)
+	} else {
+		s.Versions[name] = version
+	}
+	return previous
+}
+
+// withVersions wraps dst so that every name reaches the latest version of
+// the file, which the comparisons then use
+func withVersions(dst DestFS, state *State) DestFS {
+	if local, ok := dst.(localFS); ok {
+		return renamingLocalFS{renamingFS{dst, state.versionOf}, local}
+	}
+	return renamingFS{dst, state.versionOf}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/fs"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAuditPercent is the share of the files checked by each audit when
+// audit_percent is not set, so that the whole destination is covered in
+// ten runs
+const defaultAuditPercent = 10
+
+var audit = flag.Bool("audit", false, "rehash part of the destination against the recorded hashes instead of syncing")
+
+// errCorrupt is returned by audits that found files whose content changed
+// without their size or modification time changing
+var errCorrupt = errors.New("corrupt files found")
+
+// auditStats counts the outcomes of an audit
+type auditStats struct {
+	Audited, Bytes, Corrupt, Changed, Missing, Baselined, Errors atomic.Int64
+}
+
+// runAudit rehashes the destination files that were audited the longest
+// ago, audit_percent of the synced files per run, and compares them with
+// the hashes recorded in the state DB to find bit rot. Files without a
+// recorded hash get one, as the reference for the next audits.
+func runAudit(config Config) error {
+	if config.StateFile == "" {
+		return errNoStateFile
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return err
+	}
+	dst := storedDest(config)
+
+	// Least recently audited first, never audited before anything else
+	names := make([]string, 0, len(state.Files))
+	for name := range state.Files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := state.Files[names[i]].Audited, state.Files[names[j]].Audited
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return names[i] < names[j]
+	})
+	count := (len(names)*config.AuditPercent + 99) / 100
+	names = names[:count]
+
+	var stats auditStats
+	start := time.Now()
+	jobs := make(chan string, 100)
+	var wg sync.WaitGroup
+	for w := 1; w <= config.HashWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				auditFile(dst, name, config.Hash, state, &stats)
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := state.save(config.StateFile); err != nil {
+		return err
+	}
+	logf("Audited %d of %d files (%s) in %s: %d corrupt, %d changed, %d missing, %d given a reference hash, %d errors\n",
+		stats.Audited.Load(), len(state.Files), formatBytes(stats.Bytes.Load()), time.Since(start).Round(time.Second),
+		stats.Corrupt.Load(), stats.Changed.Load(), stats.Missing.Load(), stats.Baselined.Load(), stats.Errors.Load())
+	if stats.Corrupt.Load() > 0 {
+		return errCorrupt
+	}
+	return nil
+}
+
+// auditFile checks the destination file name, in its latest version with
+// append_only, against its record in state
+func auditFile(dst DestFS, name, algorithm string, state *State, stats *auditStats) {
+	stored := state.versionOf(name)
+	path := displayPath(dst, stored)
+	state.mu.Lock()
+	file := state.Files[name]
+	state.mu.Unlock()
+
+	info, err := dst.Stat(stored)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		errorf("Missing %s\n", path)
+		stats.Missing.Add(1)
+		return
+	case err != nil:
+		errorf("Error reading %s: %v\n", path, err)
+		stats.Errors.Add(1)
+		return
+	case !file.matches(info, modifyWindow(dst)):
+		// Rewritten since the sync, which the next sync takes care of
+		debugf("Skipping %s: changed since it was synced\n", path)
+		stats.Changed.Add(1)
+		return
+	}
+
+	sum, err := hashFile(dst, stored, algorithm)
+	if err != nil {
+		errorf("Error reading %s: %v\n", path, err)
+		stats.Errors.Add(1)
+		return
+	}
+	stats.Audited.Add(1)
+	stats.Bytes.Add(info.Size())
+
+	recorded, ok := parseHash(file.Hash, algorithm)
+	switch {
+	case !ok:
+		file.Hash = formatHash(algorithm, sum)
+		stats.Baselined.Add(1)
+	case !bytes.Equal(sum, recorded):
+		errorf("Corrupt %s: %s hash %x, %x when synced\n", path, algorithm, sum, recorded)
+		stats.Corrupt.Add(1)
+	default:
+		debugf("Verified %s\n", path)
+	}
+
+	file.Audited = time.Now()
+	state.mu.Lock()
+	state.Files[name] = file
+	state.mu.Unlock()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var errBackupUnsupported = errors.New("backup_dir requires a destination on disk")
+
+// backupRunFormat names the folder of each run under backup_dir with
+// backup_keep, sorting in the order of the runs
+const backupRunFormat = "2006-01-02_150405"
+
+// backupPath returns where the destination file name goes when it is
+// replaced or deleted: under backup_dir, which is relative to the
+// destination unless absolute, and in the folder of this run with
+// backup_keep. It is empty without a backup_dir.
+func (s *syncer) backupPath(name string) string {
+	if s.config.BackupDir == "" {
+		return ""
+	}
+	root := s.backupRoot()
+	if s.config.versionedBackups() {
+		root = filepath.Join(root, s.stats.Start.Format(backupRunFormat))
+	}
+	return filepath.Join(root, filepath.FromSlash(destName(s.dst, name)))
+}
+
+// backupRoot is the backup_dir folder on disk
+func (s *syncer) backupRoot() string {
+	if filepath.IsAbs(s.config.BackupDir) {
+		return s.config.BackupDir
+	}
+	return filepath.Join(s.config.Destination, s.config.BackupDir)
+}
+
+// inBackupDir reports whether the destination entry name is the backup_dir
+// folder, when it lies inside the destination
+func (s *syncer) inBackupDir(name string) bool {
+	local, ok := s.dst.(localFS)
+	return ok && s.config.BackupDir != "" && filepath.Clean(local.localPath(name)) == filepath.Clean(s.backupRoot())
+}
+
+// pruneBackups removes the run folders of backup_dir that the retention
+// policy does not keep
+func (s *syncer) pruneBackups() {
+	expired, err := expiredBackups(s.backupRoot(), s.config)
+	if err != nil {
+		errorf("Error listing backups in %s: %v\n", s.backupRoot(), err)
+		s.stats.addError()
+		return
+	}
+	for _, path := range expired {
+		if err := os.RemoveAll(path); err != nil {
+			errorf("Error removing old backup %s: %v\n", path, err)
+			s.stats.addError()
+			continue
+		}
+		logf("Removed old backup %s\n", path)
+	}
+}
+
+// expiredBackups returns the run folders in the backup_dir root that the
+// retention policy does not keep, leaving anything else found there alone
+func expiredBackups(root string, config Config) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []string
+	for _, entry := range entries {
+		if _, err := time.Parse(backupRunFormat, entry.Name()); err == nil && entry.IsDir() {
+			runs = append(runs, entry.Name())
+		}
+	}
+	keep := retainedRuns(runs, config, time.Now())
+	var expired []string
+	for _, run := range runs {
+		if !keep[run] {
+			expired = append(expired, filepath.Join(root, run))
+		}
+	}
+	return expired, nil
+}
+
+// moveToBackup moves the destination file name to target, its backupPath,
+// replacing the backup of an earlier run without backup_keep
+func (s *syncer) moveToBackup(name, target string) error {
+	local, ok := s.dst.(localFS)
+	if !ok {
+		return errBackupUnsupported
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := moveFile(local.localPath(name), target); err != nil {
+		return fmt.Errorf("cannot back up to %s: %v", target, err)
+	}
+	return nil
+}
+
+// moveFile renames from to to, copying then removing from when they are on
+// different volumes
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	info, statErr := os.Stat(from)
+	if statErr != nil || !info.Mode().IsRegular() {
+		return err
+	}
+
+	if err := copyLocalFile(from, to, info); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// copyLocalFile copies the regular file from, described by info, to to,
+// keeping its permissions and modification time
+func copyLocalFile(from, to string, info fs.FileInfo) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(to, time.Now(), info.ModTime())
+}
+
+// runRollback undoes at the destination the operations of the last run
+// recorded in the journal, latest first: new files are removed, and the
+// files replaced or deleted are restored from backup_dir
+func runRollback(config Config) error {
+	if config.JournalFile == "" {
+		return fmt.Errorf("journal_file is not set")
+	}
+	ops, err := readJournal(config.JournalFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no run to roll back: %s not found", config.JournalFile)
+	}
+	if err != nil {
+		return err
+	}
+	dst := withTargetNames(newOSFS(config.Destination), config).(localFS)
+
+	var undone, failed int
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Seq > ops[j].Seq })
+	for _, op := range ops {
+		path := dst.localPath(op.Path)
+		var err error
+		switch {
+		case op.Op == opCopy && op.Created, op.Op == opMkdir && op.Created:
+			// Partial copies go too. Directories are empty by now, their
+			// contents coming later in the journal.
+			err = os.Remove(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		case !op.Done, op.Op == opRun:
+			continue
+		case op.Op == opRemoveSource:
+			logf("Not undoing the removal of %s from the source\n", op.Path)
+			continue
+		case op.Op == opRename:
+			from := dst.localPath(op.From)
+			if err = os.MkdirAll(filepath.Dir(from), 0755); err == nil {
+				err = os.Rename(path, from)
+			}
+		case op.Op == opRemoveDir:
+			err = os.MkdirAll(path, 0755)
+		case op.Op == opBackup, op.Op == opDelete && op.Backup != "":
+			err = moveFile(op.Backup, path)
+		case op.Op == opCopy && op.Backup != "":
+			// Undone by restoring its backup, which comes next
+			continue
+		default:
+			err = fmt.Errorf("no backup of the previous version")
+		}
+
+		if err != nil {
+			errorf("Cannot undo %s %s: %v\n", op.Op, path, err)
+			failed++
+			continue
+		}
+		logf("Undid %s %s\n", op.Op, path)
+		undone++
+	}
+
+	// Rolling back twice would remove files restored by the first rollback
+	if err := os.Rename(config.JournalFile, config.JournalFile+".rolled-back"); err != nil {
+		return err
+	}
+	logf("Rolled back %d operations, %d could not be undone\n", undone, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d operations could not be undone", failed)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Buffer sizes and worker counts tried by the bench command
+var (
+	benchBufferSizes  = []int{32 << 10, 128 << 10, 1 << 20, 4 << 20}
+	benchWorkerCounts = []int{1, 2, 4, 8}
+)
+
+// benchTolerance is how close to the best result a setting must be to be
+// recommended instead of it, so that smaller values win ties
+const benchTolerance = 0.05
+
+// runBench measures read, write and hash throughput between the configured
+// source and destination and recommends worker and buffer_size settings
+func runBench(config Config, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	sizeMiB := flags.Int64("size", 256, "MiB of data used by each measurement")
+	flags.Parse(args)
+	size := *sizeMiB << 20
+
+	dir, err := os.MkdirTemp(config.Destination, ".gosync-bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, benchBufferSizes[len(benchBufferSizes)-1])
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+
+	fmt.Printf("Write to %s:\n", config.Destination)
+	writeRates := make(map[int]float64)
+	for _, bufSize := range benchBufferSizes {
+		rate, err := benchWrite(filepath.Join(dir, "write"), data[:bufSize], size)
+		if err != nil {
+			return err
+		}
+		writeRates[bufSize] = rate
+		fmt.Printf("  buffer %-10s %s/s\n", formatBytes(int64(bufSize)), formatBytes(int64(rate)))
+	}
+	bufSize := pickBest(benchBufferSizes, writeRates)
+
+	fmt.Printf("Read from %s:\n", config.Source)
+	groups, err := benchSourceFiles(config.Source, size, len(benchBufferSizes))
+	if err != nil {
+		return err
+	}
+	if groups == nil {
+		fmt.Println("  no files to read")
+	}
+	for i, files := range groups {
+		rate, err := benchRead(files, make([]byte, benchBufferSizes[i]))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  buffer %-10s %s/s\n", formatBytes(int64(benchBufferSizes[i])), formatBytes(int64(rate)))
+	}
+
+	fmt.Println("Hash:")
+	for _, algorithm := range hashAlgorithms {
+		fmt.Printf("  %-17s %s/s\n", algorithm.label, formatBytes(int64(benchHash(algorithm.name, data, size))))
+	}
+
+	fmt.Printf("Concurrent writes with a %s buffer:\n", formatBytes(int64(bufSize)))
+	workerRates := make(map[int]float64)
+	for _, workers := range benchWorkerCounts {
+		rate, err := benchWorkers(dir, data[:bufSize], workers, size)
+		if err != nil {
+			return err
+		}
+		workerRates[workers] = rate
+		fmt.Printf("  %-17s %s/s\n", fmt.Sprintf("%d workers", workers), formatBytes(int64(rate)))
+	}
+
+	fmt.Printf("\nRecommended settings: \"worker\": %d, \"buffer_size\": %d\n", pickBest(benchWorkerCounts, workerRates), bufSize)
+	return nil
+}
+
+// benchWrite writes size bytes to path in chunks of len(buf), returning the throughput
+func benchWrite(path string, buf []byte, size int64) (float64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(path)
+
+	start := time.Now()
+	for written := int64(0); written < size; written += int64(len(buf)) {
+		if _, err := f.Write(buf); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+	// Include the time to reach the disk, not just the page cache
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return float64(size) / time.Since(start).Seconds(), nil
+}
+
+// benchSourceFiles picks source files holding about size bytes for each of n
+// measurements, so that each one reads files not yet in the cache when possible
+func benchSourceFiles(root string, size int64, n int) ([][]string, error) {
+	var files []string
+	var total int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			return nil
+		}
+		files = append(files, path)
+		total += info.Size()
+		if total >= size*int64(n) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	groups := make([][]string, n)
+	for i, file := range files {
+		groups[i%n] = append(groups[i%n], file)
+	}
+	for i := range groups {
+		if len(groups[i]) == 0 {
+			groups[i] = files
+		}
+	}
+	return groups, nil
+}
+
+// benchRead reads files using buf, returning the throughput
+func benchRead(files []string, buf []byte) (float64, error) {
+	var total int64
+	start := time.Now()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{f}, buf)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return float64(total) / time.Since(start).Seconds(), nil
+}
+
+// benchHash hashes size bytes from memory with the algorithm, returning the
+// throughput
+func benchHash(algorithm string, data []byte, size int64) float64 {
+	h := newHash(algorithm)
+	start := time.Now()
+	for hashed := int64(0); hashed < size; hashed += int64(len(data)) {
+		h.Write(data)
+	}
+	h.Sum(nil)
+	return float64(size) / time.Since(start).Seconds()
+}
+
+// benchWorkers writes size bytes split across workers concurrent files,
+// returning the aggregate throughput
+func benchWorkers(dir string, buf []byte, workers int, size int64) (float64, error) {
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			_, errs[w] = benchWrite(filepath.Join(dir, fmt.Sprintf("worker%d", w)), buf, size/int64(workers))
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return float64(size) / elapsed, nil
+}
+
+// pickBest returns the smallest of the ascending candidates whose rate is
+// within benchTolerance of the best one
+func pickBest(candidates []int, rates map[int]float64) int {
+	best := 0.0
+	for _, c := range candidates {
+		best = max(best, rates[c])
+	}
+	for _, c := range candidates {
+		if rates[c] >= best*(1-benchTolerance) {
+			return c
+		}
+	}
+	return candidates[0]
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// BLAKE3 parameters, following the reference implementation
+const (
+	blake3ChunkLen = 1024
+	blake3BlockLen = 64
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+// blake3Schedule is the order of the message words in each round, the
+// permutation of the specification applied round after round
+var blake3Schedule = [7][16]uint8{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8},
+	{3, 4, 10, 12, 13, 2, 7, 14, 6, 5, 9, 0, 11, 15, 8, 1},
+	{10, 7, 12, 9, 14, 3, 13, 15, 4, 0, 11, 2, 5, 8, 1, 6},
+	{12, 13, 9, 11, 15, 10, 14, 8, 7, 2, 5, 3, 0, 1, 6, 4},
+	{9, 14, 11, 5, 8, 12, 15, 1, 13, 3, 0, 10, 2, 6, 4, 7},
+	{11, 15, 5, 0, 1, 9, 8, 6, 14, 10, 2, 12, 3, 4, 7, 13},
+}
+
+func blake3G(a, b, c, d, mx, my uint32) (uint32, uint32, uint32, uint32) {
+	a += b + mx
+	d = bits.RotateLeft32(d^a, -16)
+	c += d
+	b = bits.RotateLeft32(b^c, -12)
+	a += b + my
+	d = bits.RotateLeft32(d^a, -8)
+	c += d
+	b = bits.RotateLeft32(b^c, -7)
+	return a, b, c, d
+}
+
+func blake3Compress(cv *[8]uint32, m *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	v0, v1, v2, v3, v4, v5, v6, v7 := cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7]
+	v8, v9, v10, v11 := blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3]
+	v12, v13, v14, v15 := uint32(counter), uint32(counter>>32), blockLen, flags
+
+	for i := range blake3Schedule {
+		s := &blake3Schedule[i]
+		v0, v4, v8, v12 = blake3G(v0, v4, v8, v12, m[s[0]&15], m[s[1]&15])
+		v1, v5, v9, v13 = blake3G(v1, v5, v9, v13, m[s[2]&15], m[s[3]&15])
+		v2, v6, v10, v14 = blake3G(v2, v6, v10, v14, m[s[4]&15], m[s[5]&15])
+		v3, v7, v11, v15 = blake3G(v3, v7, v11, v15, m[s[6]&15], m[s[7]&15])
+		v0, v5, v10, v15 = blake3G(v0, v5, v10, v15, m[s[8]&15], m[s[9]&15])
+		v1, v6, v11, v12 = blake3G(v1, v6, v11, v12, m[s[10]&15], m[s[11]&15])
+		v2, v7, v8, v13 = blake3G(v2, v7, v8, v13, m[s[12]&15], m[s[13]&15])
+		v3, v4, v9, v14 = blake3G(v3, v4, v9, v14, m[s[14]&15], m[s[15]&15])
+	}
+
+	return [16]uint32{
+		v0 ^ v8, v1 ^ v9, v2 ^ v10, v3 ^ v11, v4 ^ v12, v5 ^ v13, v6 ^ v14, v7 ^ v15,
+		v8 ^ cv[0], v9 ^ cv[1], v10 ^ cv[2], v11 ^ cv[3], v12 ^ cv[4], v13 ^ cv[5], v14 ^ cv[6], v15 ^ cv[7],
+	}
+}
+
+func blake3Words(block *[blake3BlockLen]byte) [16]uint32 {
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+	return words
+}
+
+// blake3Output is a node of the tree not compressed yet, so that the root
+// can be compressed with the root flag
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	return [8]uint32(s[:8])
+}
+
+func (o *blake3Output) rootBytes(b []byte) []byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	for _, word := range s[:8] {
+		b = binary.LittleEndian.AppendUint32(b, word)
+	}
+	return b
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+type blake3Chunk struct {
+	cv         [8]uint32
+	counter    uint64
+	block      [blake3BlockLen]byte
+	blockLen   int
+	compressed int
+}
+
+func (c *blake3Chunk) len() int {
+	return c.compressed*blake3BlockLen + c.blockLen
+}
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.compressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) update(p []byte) {
+	for len(p) > 0 {
+		if c.blockLen == blake3BlockLen {
+			c.compress(&c.block)
+		}
+		// Whole blocks are compressed straight from p, except the last one,
+		// which is compressed differently if it ends the chunk
+		for c.blockLen == 0 && len(p) > blake3BlockLen {
+			c.compress((*[blake3BlockLen]byte)(p))
+			p = p[blake3BlockLen:]
+		}
+		copied := copy(c.block[c.blockLen:], p)
+		c.blockLen += copied
+		p = p[copied:]
+	}
+}
+
+func (c *blake3Chunk) compress(block *[blake3BlockLen]byte) {
+	words := blake3Words(block)
+	s := blake3Compress(&c.cv, &words, c.counter, blake3BlockLen, c.startFlag())
+	c.cv = [8]uint32(s[:8])
+	c.compressed++
+	c.block = [blake3BlockLen]byte{}
+	c.blockLen = 0
+}
+
+func (c *blake3Chunk) output() blake3Output {
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(&c.block),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3 is the unkeyed BLAKE3 hash with a 256-bit output, cryptographic
+// like SHA-256 but several times faster
+type blake3 struct {
+	chunk blake3Chunk
+	stack [][8]uint32 // chaining values of the complete subtrees
+}
+
+func newBLAKE3() hash.Hash {
+	h := &blake3{}
+	h.Reset()
+	return h
+}
+
+func (h *blake3) Reset() {
+	h.chunk = blake3Chunk{cv: blake3IV}
+	h.stack = h.stack[:0]
+}
+
+func (h *blake3) Size() int      { return 32 }
+func (h *blake3) BlockSize() int { return blake3BlockLen }
+
+func (h *blake3) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if h.chunk.len() == blake3ChunkLen {
+			output := h.chunk.output()
+			h.pushChunk(output.chainingValue(), h.chunk.counter+1)
+			h.chunk = blake3Chunk{cv: blake3IV, counter: h.chunk.counter + 1}
+		}
+		take := min(blake3ChunkLen-h.chunk.len(), len(p))
+		h.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// pushChunk adds the chaining value of a complete chunk, merging the
+// subtrees it completes: as many as trailing zero bits in total
+func (h *blake3) pushChunk(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		parent := blake3ParentOutput(h.stack[len(h.stack)-1], cv)
+		cv = parent.chainingValue()
+		h.stack = h.stack[:len(h.stack)-1]
+		total >>= 1
+	}
+	h.stack = append(h.stack, cv)
+}
+
+func (h *blake3) Sum(b []byte) []byte {
+	output := h.chunk.output()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		output = blake3ParentOutput(h.stack[i], output.chainingValue())
+	}
+	return output.rootBytes(b)
+}
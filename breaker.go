@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of the circuit breaker
+const (
+	defaultErrorThreshold = 5
+	defaultErrorBackoff   = 30 * time.Second
+	maxErrorBackoff       = 10 * time.Minute
+)
+
+// breaker pauses all the workers once the destination fails repeatedly,
+// probing it with backoff until it responds again, rather than letting
+// every worker fail on its own
+type breaker struct {
+	threshold int
+	backoff   time.Duration
+	probe     func() error
+
+	mu       sync.Mutex
+	failures int           // consecutive failures
+	open     chan struct{} // closed on recovery, nil while closed itself
+}
+
+func newBreaker(threshold int, backoff time.Duration, probe func() error) *breaker {
+	return &breaker{threshold: threshold, backoff: backoff, probe: probe}
+}
+
+// wait blocks while the breaker is open
+func (b *breaker) wait() {
+	b.mu.Lock()
+	open := b.open
+	b.mu.Unlock()
+	if open != nil {
+		<-open
+	}
+}
+
+// success resets the count of consecutive failures
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// failure counts a failure, opening the breaker at the threshold
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open != nil {
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open = make(chan struct{})
+		go b.recover()
+	}
+}
+
+// recover probes the destination with exponential backoff, then lets the
+// workers resume
+func (b *breaker) recover() {
+	delay := b.backoff
+	errorf("%d errors in a row, pausing all workers for %s\n", b.threshold, delay)
+	for {
+		time.Sleep(delay)
+		err := b.probe()
+		if err == nil {
+			break
+		}
+		delay = min(delay*2, maxErrorBackoff)
+		errorf("Destination still failing (%v), retrying in %s\n", err, delay)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	logf("Destination responding again, resuming\n")
+	close(b.open)
+	b.open = nil
+	b.failures = 0
+}
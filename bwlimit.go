@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minutesPerWeek is the length of the cycle of a bandwidth timetable
+const minutesPerWeek = 7 * 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// bandwidth limits the copy speed of all the workers together, nil unless
+// bwlimit is set
+var bandwidth *bandwidthLimiter
+
+// bwChange is the start of a period of the timetable, in minutes since
+// Sunday midnight, with its rate in bytes per second (0 for unlimited)
+type bwChange struct {
+	minute int
+	rate   int64
+}
+
+// bwSchedule is a bandwidth timetable, sorted by minute
+type bwSchedule []bwChange
+
+// parseBwLimit parses a bandwidth limit in the format of rclone's --bwlimit:
+// either a single rate, or space-separated "[Day-]HH:MM,rate" entries, each
+// applying from its time until the next one. An entry without a day applies
+// every day. Rates are in KiB/s unless suffixed with B, K, M or G, and "off"
+// is unlimited.
+func parseBwLimit(spec string) (bwSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty bandwidth limit")
+	}
+	if len(fields) == 1 && !strings.Contains(fields[0], ",") {
+		rate, err := parseRate(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return bwSchedule{{0, rate}}, nil
+	}
+
+	var schedule bwSchedule
+	for _, field := range fields {
+		at, value, ok := strings.Cut(field, ",")
+		if !ok {
+			return nil, fmt.Errorf("missing rate in %q", field)
+		}
+		rate, err := parseRate(value)
+		if err != nil {
+			return nil, err
+		}
+
+		days := []time.Weekday{0, 1, 2, 3, 4, 5, 6}
+		if day, clock, ok := strings.Cut(at, "-"); ok {
+			weekday, known := weekdays[strings.ToLower(day)]
+			if !known {
+				return nil, fmt.Errorf("invalid day in %q", field)
+			}
+			days, at = []time.Weekday{weekday}, clock
+		}
+
+		clock, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time in %q", field)
+		}
+		for _, day := range days {
+			minute := int(day)*24*60 + clock.Hour()*60 + clock.Minute()
+			schedule = append(schedule, bwChange{minute, rate})
+		}
+	}
+
+	sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].minute < schedule[j].minute })
+	return schedule, nil
+}
+
+// parseRate parses a rate such as "512k" or "1.5M" into bytes per second
+func parseRate(s string) (int64, error) {
+	if strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+
+	unit := 1024.0
+	if i := len(s) - 1; i >= 0 {
+		switch s[i] {
+		case 'b', 'B':
+			unit, s = 1, s[:i]
+		case 'k', 'K':
+			s = s[:i]
+		case 'm', 'M':
+			unit, s = 1<<20, s[:i]
+		case 'g', 'G':
+			unit, s = 1<<30, s[:i]
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(value * unit), nil
+}
+
+// rateAt returns the rate in force at t, in bytes per second
+func (s bwSchedule) rateAt(t time.Time) int64 {
+	minute := int(t.Weekday())*24*60 + t.Hour()*60 + t.Minute()
+
+	// Before the first change of the week, the last one of the previous week applies
+	rate := s[len(s)-1].rate
+	for _, change := range s {
+		if change.minute > minute {
+			break
+		}
+		rate = change.rate
+	}
+	return rate
+}
+
+// bandwidthLimiter delays reads so that, together, they don't go faster
+// than the rate of the timetable
+type bandwidthLimiter struct {
+	schedule bwSchedule
+
+	mu   sync.Mutex
+	rate int64
+	next time.Time // when the reads already let through are paid for
+}
+
+func newBandwidthLimiter(spec string) (*bandwidthLimiter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	schedule, err := parseBwLimit(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &bandwidthLimiter{schedule: schedule, rate: -1}, nil
+}
+
+// wait blocks until n more bytes can be transferred
+func (l *bandwidthLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+
+	// A change of the timetable applies right away, even to running copies
+	if rate := l.schedule.rateAt(now); rate != l.rate {
+		if rate == 0 {
+			debugf("Bandwidth limit: off\n")
+		} else {
+			debugf("Bandwidth limit: %s/s\n", formatBytes(rate))
+		}
+		l.rate, l.next = rate, now
+	}
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// reader wraps r so that reads are held to the bandwidth limit
+func (l *bandwidthLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r, l}
+}
+
+type limitedReader struct {
+	io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBwLimit(t *testing.T) {
+	// 2026-10-11 is a Sunday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, 11+day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec string
+		at   time.Time
+		want int64
+	}{
+		{"512", at(3, 12, 0), 512 << 10},
+		{"512k", at(3, 12, 0), 512 << 10},
+		{"1.5M", at(3, 12, 0), 3 << 19},
+		{"2G", at(3, 12, 0), 2 << 30},
+		{"100B", at(3, 12, 0), 100},
+		{"off", at(3, 12, 0), 0},
+
+		// Every day
+		{"08:00,1M 18:00,off", at(3, 7, 59), 0},
+		{"08:00,1M 18:00,off", at(3, 8, 0), 1 << 20},
+		{"08:00,1M 18:00,off", at(3, 17, 59), 1 << 20},
+		{"08:00,1M 18:00,off", at(3, 18, 0), 0},
+
+		// Weekly, the last change of the week applying until the first
+		{"Mon-08:00,1M Fri-18:00,512k", at(0, 12, 0), 512 << 10},
+		{"Mon-08:00,1M Fri-18:00,512k", at(1, 7, 59), 512 << 10},
+		{"Mon-08:00,1M Fri-18:00,512k", at(1, 8, 0), 1 << 20},
+		{"Mon-08:00,1M Fri-18:00,512k", at(3, 12, 0), 1 << 20},
+		{"Mon-08:00,1M Fri-18:00,512k", at(5, 18, 0), 512 << 10},
+		{"sat-00:00,off mon-00:00,10M", at(6, 9, 0), 0},
+	}
+	for _, tt := range tests {
+		schedule, err := parseBwLimit(tt.spec)
+		if err != nil {
+			t.Errorf("parseBwLimit(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.rateAt(tt.at); got != tt.want {
+			t.Errorf("parseBwLimit(%q).rateAt(%s) = %d, want %d", tt.spec, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseBwLimitErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"fast",
+		"-1k",
+		"08:00",
+		"08:00,1M 18:00",
+		"25:00,1M",
+		"Xyz-08:00,1M",
+		"08:00,lots",
+	} {
+		if _, err := parseBwLimit(spec); err == nil {
+			t.Errorf("parseBwLimit(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values accepted by the destination_format option
+const (
+	formatMirror = "mirror" // a copy of the source tree (default)
+	formatChunks = "chunks" // a repository of deduplicated chunks and snapshots
+)
+
+// Bounds of the content-defined chunks: a chunk ends where the low bits of
+// the rolling hash are zero, so an insertion only changes the chunks around
+// it and the others are found again in the store
+const (
+	minChunkSize  = 512 << 10
+	maxChunkSize  = 8 << 20
+	chunkHashMask = 1<<20 - 1 // about 1 MiB chunks on average
+)
+
+// Layout of a chunk repository
+const (
+	chunksDir      = "chunks"    // chunks/<first 2 hex digits>/<sha256>
+	snapshotsDir   = "snapshots" // snapshots/<time>.json, one per run
+	snapshotFormat = "2006-01-02_150405"
+)
+
+// snapshot is the tree of the source as a run stored it
+type snapshot struct {
+	Time   time.Time       `json:"time"`
+	Source string          `json:"source"`
+	Files  []snapshotEntry `json:"files"` // in walk order, directories first
+}
+
+// snapshotEntry is a file or directory of a snapshot
+type snapshotEntry struct {
+	Path    string      `json:"path"`
+	Dir     bool        `json:"dir,omitempty"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Chunks  []string    `json:"chunks,omitempty"` // sha256 of the chunks, in order
+}
+
+// gearTable drives the rolling hash of the chunker. Changing it changes
+// every chunk boundary, so it is derived from a fixed seed.
+var gearTable = func() (table [256]uint64) {
+	x := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		// splitmix64
+		x += 0x9E3779B97F4A7C15
+		z := x
+		z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+		z = (z ^ z>>27) * 0x94D049BB133111EB
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
+
+// splitChunks reads r to the end, calling chunk with each content-defined
+// chunk. The slice is only valid during the call.
+func splitChunks(r io.Reader, chunk func([]byte) error) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	buf := make([]byte, 0, maxChunkSize)
+	var h uint64
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b)
+		h = h<<1 + gearTable[b]
+		if len(buf) >= maxChunkSize || len(buf) >= minChunkSize && h&chunkHashMask == 0 {
+			if err := chunk(buf); err != nil {
+				return err
+			}
+			buf, h = buf[:0], 0
+		}
+	}
+	if len(buf) > 0 {
+		return chunk(buf)
+	}
+	return nil
+}
+
+// chunkStore is a repository of chunks named by their SHA-256, and of the
+// snapshots listing them. Chunks stored compressed have the gzipSuffix; the
+// name is always the hash of the uncompressed content, so that a chunk is
+// stored once either way.
+type chunkStore struct {
+	root  string
+	level int // of the compression
+	mu    sync.Mutex
+	known map[string]bool // chunks found in the store during this run
+
+	jobs        chan chunkJob // nil without compressors
+	compressors sync.WaitGroup
+}
+
+// chunkJob is a chunk handed to the compressors
+type chunkJob struct {
+	data     []byte
+	compress bool
+	done     chan chunkResult
+}
+
+// chunkResult is the outcome of storing a chunk
+type chunkResult struct {
+	id    string
+	added bool
+	err   error
+}
+
+// openChunkStore opens the repository at root, creating it when needed,
+// to compress chunks at level
+func openChunkStore(root string, level int) (*chunkStore, error) {
+	for _, dir := range []string{chunksDir, snapshotsDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &chunkStore{root: root, level: level, known: make(map[string]bool)}, nil
+}
+
+func (c *chunkStore) chunkPath(id string) string {
+	return filepath.Join(c.root, chunksDir, id[:2], id)
+}
+
+// put stores the chunk data unless the store has it already, compressed if
+// asked and smaller that way, returning its id and whether it was new
+func (c *chunkStore) put(data []byte, compress bool) (string, bool, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	known := c.known[id]
+	c.mu.Unlock()
+	if known {
+		return id, false, nil
+	}
+	path := c.chunkPath(id)
+	if c.has(id) {
+		c.mu.Lock()
+		c.known[id] = true
+		c.mu.Unlock()
+		return id, false, nil
+	}
+
+	if compress {
+		compressed, err := compressChunk(data, c.level)
+		if err != nil {
+			return "", false, err
+		}
+		if len(compressed) < len(data) {
+			data, path = compressed, path+gzipSuffix
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", false, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", false, err
+	}
+	c.mu.Lock()
+	c.known[id] = true
+	c.mu.Unlock()
+	return id, true, nil
+}
+
+// startCompressors starts n goroutines storing the submitted chunks, so
+// that compressing them scales with the cores instead of holding up the
+// file workers
+func (c *chunkStore) startCompressors(n int) {
+	c.jobs = make(chan chunkJob)
+	for i := 0; i < n; i++ {
+		c.compressors.Add(1)
+		go func() {
+			defer c.compressors.Done()
+			for job := range c.jobs {
+				id, added, err := c.put(job.data, job.compress)
+				job.done <- chunkResult{id, added, err}
+			}
+		}()
+	}
+}
+
+// stopCompressors waits for the submitted chunks to be stored
+func (c *chunkStore) stopCompressors() {
+	if c.jobs != nil {
+		close(c.jobs)
+		c.compressors.Wait()
+	}
+}
+
+// submit stores the chunk data through the compressors when started, in
+// place otherwise, returning where the outcome arrives. The store keeps
+// its own copy of data.
+func (c *chunkStore) submit(data []byte, compress bool) <-chan chunkResult {
+	done := make(chan chunkResult, 1)
+	if c.jobs == nil {
+		id, added, err := c.put(data, compress)
+		done <- chunkResult{id, added, err}
+		return done
+	}
+	c.jobs <- chunkJob{append([]byte(nil), data...), compress, done}
+	return done
+}
+
+// has reports whether the store has the chunk id, compressed or not
+func (c *chunkStore) has(id string) bool {
+	for _, path := range []string{c.chunkPath(id), c.chunkPath(id) + gzipSuffix} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// get reads the chunk id, checking it against its name
+func (c *chunkStore) get(id string) ([]byte, error) {
+	data, err := os.ReadFile(c.chunkPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		if data, err = os.ReadFile(c.chunkPath(id) + gzipSuffix); err == nil {
+			data, err = decompressChunk(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupted", id)
+	}
+	return data, nil
+}
+
+// snapshots returns the ids of the snapshots, oldest first
+func (c *chunkStore) snapshots() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(c.root, snapshotsDir))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// loadSnapshot reads the snapshot id
+func (c *chunkStore) loadSnapshot(id string) (*snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(c.root, snapshotsDir, id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no snapshot %q", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %v", id, err)
+	}
+	return snap, nil
+}
+
+// latestSnapshot reads the newest snapshot, nil when there is none
+func (c *chunkStore) latestSnapshot() (*snapshot, error) {
+	ids, err := c.snapshots()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return c.loadSnapshot(ids[len(ids)-1])
+}
+
+// saveSnapshot writes snap, once all its chunks are stored, returning its
+// id: its time, numbered when several runs start within the same second
+func (c *chunkStore) saveSnapshot(snap *snapshot) (string, error) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return "", err
+	}
+	id := snap.Time.Format(snapshotFormat)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(c.root, snapshotsDir, id+".json")); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", snap.Time.Format(snapshotFormat), n)
+	}
+	return id, writeFileAtomic(filepath.Join(c.root, snapshotsDir, id+".json"), data)
+}
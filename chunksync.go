@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// syncChunks backs src up into the chunk repository at the destination, as
+// a new snapshot. Files unchanged since the previous snapshot reuse its
+// chunks without being read; the others are split into chunks, of which
+// only those missing from the store are written.
+func syncChunks(src fs.FS, config Config) (*Stats, error) {
+	stats := newStats()
+	stats.top = newTopFiles(config.TopFiles)
+	store, err := openChunkStore(config.Destination, config.CompressionLevel)
+	if err != nil {
+		return stats, fmt.Errorf("cannot open chunk store %s: %v", config.Destination, err)
+	}
+	filter, err := newFilter(config)
+	if err != nil {
+		return stats, err
+	}
+	if len(config.ExcludeCmd) > 0 {
+		if err := filter.runExcludeCmd(src, config.ExcludeCmd); err != nil {
+			return stats, fmt.Errorf("exclude_cmd failed: %v", err)
+		}
+	}
+
+	previous := make(map[string]snapshotEntry)
+	if last, err := store.latestSnapshot(); err != nil {
+		return stats, fmt.Errorf("cannot read the last snapshot: %v", err)
+	} else if last != nil {
+		for _, entry := range last.Files {
+			previous[entry.Path] = entry
+		}
+	}
+
+	// The tree of the snapshot, whose files the workers fill with chunks
+	snap := &snapshot{Time: stats.Start, Source: config.Source}
+	err = fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if reason, excluded := filter.exclude(name, info); excluded {
+			skippedf("Skipping %s: %s\n", displayPath(src, name), reason)
+			stats.addSkipped()
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if name != "." && (d.IsDir() || info.Mode().IsRegular()) {
+			snap.Files = append(snap.Files, snapshotEntry{Path: name, Dir: d.IsDir(), Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if config.Compression != compressionNone {
+		store.startCompressors(runtime.NumCPU())
+	}
+
+	var newBytes int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	failed := make(map[int]bool)
+	for w := 1; w <= config.Worker; w++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for i := range indexes {
+				stored, err := storeFile(id, src, store, &snap.Files[i], previous, config, stats)
+				mu.Lock()
+				newBytes += stored
+				if err != nil {
+					failed[i] = true
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+	for i := range snap.Files {
+		if !snap.Files[i].Dir {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	store.stopCompressors()
+
+	// A file that could not be read is left out rather than snapshotted empty
+	if len(failed) > 0 {
+		files := snap.Files[:0]
+		for i, entry := range snap.Files {
+			if !failed[i] {
+				files = append(files, entry)
+			}
+		}
+		snap.Files = files
+	}
+
+	id, err := store.saveSnapshot(snap)
+	if err != nil {
+		return stats, fmt.Errorf("cannot write snapshot: %v", err)
+	}
+	logf("Snapshot %s: %d entries, %s of new chunks\n", id, len(snap.Files), formatBytes(newBytes))
+	return stats, nil
+}
+
+// storeFile fills entry with the chunks of its source file, reusing those
+// of the previous snapshot when the file did not change, and returns the
+// bytes of the chunks it added to the store
+func storeFile(id int, src fs.FS, store *chunkStore, entry *snapshotEntry, previous map[string]snapshotEntry, config Config, stats *Stats) (int64, error) {
+	path := displayPath(src, entry.Path)
+	if last, ok := previous[entry.Path]; ok && !config.Checksum && !last.Dir &&
+		last.Size == entry.Size && last.ModTime.Equal(entry.ModTime) {
+		skippedf("Worker %d: Skipping %s: %s\n", id, path, tr("unchanged since the last snapshot"))
+		entry.Chunks = last.Chunks
+		stats.addSkipped()
+		return 0, nil
+	}
+
+	start := time.Now()
+	f, err := src.Open(entry.Path)
+	if err != nil {
+		errorf("Worker %d: Error reading %s: %v\n", id, path, err)
+		stats.addError()
+		return 0, err
+	}
+	defer f.Close()
+
+	// The chunks are stored while the next ones are read, and collected in order
+	var pending []<-chan chunkResult
+	var sizes []int
+	compress := config.compresses(entry.Path)
+	err = splitChunks(f, func(data []byte) error {
+		pending = append(pending, store.submit(data, compress))
+		sizes = append(sizes, len(data))
+		return nil
+	})
+	var stored int64
+	var chunks []string
+	for i, done := range pending {
+		result := <-done
+		if result.err != nil && err == nil {
+			err = result.err
+		}
+		if result.added {
+			stored += int64(sizes[i])
+		}
+		chunks = append(chunks, result.id)
+	}
+	if err != nil {
+		errorf("Worker %d: Error storing %s: %v\n", id, path, err)
+		stats.addError()
+		return stored, err
+	}
+	entry.Chunks = chunks
+	copiedf("Worker %d: Stored %s: %d chunks, %s new\n", id, path, len(chunks), formatBytes(stored))
+	stats.addCopied(path, entry.Size, time.Since(start))
+	return stored, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+)
+
+// Reasons for not copying a file, as reported with --verbose
+const (
+	skipEqual    = "same size and modification time at the destination"
+	skipExisting = "already exists at the destination"
+	skipNotNewer = "not newer than the destination"
+	skipChecksum = "same size and checksum at the destination"
+)
+
+var update = flag.Bool("update", false, "copy only files newer than their destination copy")
+
+// shouldCopy decides whether the file name must be copied from src to dst,
+// giving the reason when it must not. The state, which may be nil, provides
+// the recorded hashes in checksum mode.
+func shouldCopy(src fs.FS, dst DestFS, name string, config Config, state *State) (bool, string, error) {
+	if config.IgnoreExisting {
+		_, err := dst.Stat(name)
+		if err == nil {
+			return false, skipExisting, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, "", err
+		}
+	}
+
+	if config.Checksum {
+		same, err := sameChecksum(src, dst, name, config.Hash, state)
+		if err != nil || same {
+			return false, skipChecksum, err
+		}
+	} else {
+		equal, err := FilesAreEqual(src, dst, name)
+		if err != nil || equal {
+			return false, skipEqual, err
+		}
+	}
+
+	// Files edited at the destination must not be replaced by stale sources
+	if config.Update {
+		sourceInfo, err := fs.Stat(src, name)
+		if err != nil {
+			return false, "", err
+		}
+		destInfo, err := dst.Stat(name)
+		if err == nil && !sourceInfo.ModTime().After(destInfo.ModTime().Add(modifyWindow(dst))) {
+			return false, skipNotNewer, nil
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, "", err
+		}
+	}
+	return true, "", nil
+}
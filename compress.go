@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// Values accepted by the compression option
+const (
+	compressionNone = "none" // default
+	compressionGzip = "gzip"
+)
+
+// gzipSuffix ends the names of the chunks stored compressed
+const gzipSuffix = ".gz"
+
+// defaultCompressSkip lists the formats that are compressed already, which
+// compressing again only slows down
+var defaultCompressSkip = []string{
+	"jpg", "jpeg", "png", "gif", "webp", "heic",
+	"mp3", "aac", "ogg", "flac", "mp4", "mkv", "mov", "avi", "webm",
+	"zip", "gz", "tgz", "bz2", "xz", "zst", "7z", "rar",
+	"docx", "xlsx", "pptx", "odt", "pdf",
+}
+
+// compresses reports whether the chunks of the source file name are to be
+// compressed
+func (c Config) compresses(name string) bool {
+	if c.Compression != compressionGzip {
+		return false
+	}
+	_, skip := matchExtension(name, c.CompressSkipExtensions)
+	return !skip
+}
+
+// compressChunk returns data compressed with gzip at level
+func compressChunk(data []byte, level int) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decompressChunk returns a chunk stored compressed as it was
+func decompressChunk(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var yes = flag.Bool("yes", false, "start without asking for confirmation, with confirm")
+
+// errNotConfirmed is returned when the plan of a run was not confirmed
+var errNotConfirmed = errors.New("the run was not confirmed")
+
+// plannedDeletions counts the files the run will delete at the destination
+// with propagate_deletes: those gone from the source since they were
+// synced, and the deletions left pending by earlier runs
+func (s *syncer) plannedDeletions(scan *scanResult, renames []plannedRename) int64 {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	n := -int64(len(renames)) // gone from the source, but renamed
+	for name := range s.state.Files {
+		if !scan.inSource(name) {
+			n++
+		}
+	}
+	for _, tombstone := range s.state.Tombstones {
+		if !tombstone.Propagated {
+			n++
+		}
+	}
+	return n
+}
+
+// confirmRun asks on the terminal whether to go on with the plan printed
+// just before, which only an answer of yes confirms
+func confirmRun() bool {
+	fmt.Fprint(os.Stderr, tr("Proceed? [y/N] "))
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch answer := strings.ToLower(strings.TrimSpace(line)); answer {
+	case "y", "yes", tr("y"), tr("yes"):
+		return true
+	}
+	return false
+}
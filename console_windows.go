@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var procSetConsoleMode = modkernel32.NewProc("SetConsoleMode")
+
+const enableVirtualTerminalProcessing = 0x0004
+
+// enableVirtualTerminal turns on ANSI escape sequence handling for f,
+// reporting whether the console supports it
+func enableVirtualTerminal(f *os.File) bool {
+	handle := syscall.Handle(f.Fd())
+
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+
+	ok, _, _ := procSetConsoleMode.Call(uintptr(handle), uintptr(mode|enableVirtualTerminalProcessing))
+	return ok != 0
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+// propagateDeletions deletes at the destination the files tombstoned in the
+// state DB, unless they were changed there since they were synced. The
+// deletions go to the workers batch_files at a time, like the copies.
+func (s *syncer) propagateDeletions() {
+	s.state.mu.Lock()
+	var names []string
+	for name, tombstone := range s.state.Tombstones {
+		if !tombstone.Propagated {
+			names = append(names, name)
+		}
+	}
+	s.state.mu.Unlock()
+	sort.Strings(names)
+
+	tasks := make(chan []task, s.config.QueueDepth)
+	var workers sync.WaitGroup
+	for w := 1; w <= s.config.Worker; w++ {
+		workers.Add(1)
+		go s.worker(w, tasks, &workers)
+	}
+	for len(names) > 0 {
+		n := min(len(names), s.config.BatchFiles)
+		batch := make([]task, n)
+		for i, name := range names[:n] {
+			batch[i] = task{kind: taskDelete, name: name}
+		}
+		tasks <- batch
+		names = names[n:]
+	}
+	close(tasks)
+	workers.Wait()
+}
+
+// deleteEntry deletes the tombstoned file name at the destination, unless
+// it changed there since it was synced
+func (s *syncer) deleteEntry(name string) {
+	s.state.mu.Lock()
+	tombstone := s.state.Tombstones[name]
+	s.state.mu.Unlock()
+	destPath := displayPath(s.dst, name)
+
+	info, err := s.dst.Stat(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		// Leave the tombstone pending, to retry on the next run
+		errorf("Error checking %s before deleting it: %v\n", destPath, err)
+		s.stats.addError()
+		return
+	case info.Size() != tombstone.Size || !sameModTime(s.dst, info.ModTime(), tombstone.ModTime):
+		errorf("Not deleting %s: it changed at the destination since it was synced\n", destPath)
+	default:
+		// With a backup_dir, the deletion is a move there
+		entry := journalEntry{Op: opDelete, Path: name, Backup: s.backupPath(name)}
+		seq := s.journal.begin(entry)
+		if entry.Backup != "" {
+			err = s.moveToBackup(name, entry.Backup)
+		} else {
+			err = s.dst.Remove(name)
+		}
+		s.journal.end(seq, err)
+		if err != nil {
+			errorf("Error deleting %s: %v\n", destPath, err)
+			s.stats.addError()
+			s.report.add(actionFailed, name, info.Size(), 0, err.Error())
+			return
+		}
+		logf("Deleted %s\n", destPath)
+		s.stats.Deleted.Add(1)
+		s.report.add(actionDeleted, name, info.Size(), 0, "")
+		s.meta.forget(name)
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	tombstone.Propagated = true
+	s.state.Tombstones[name] = tombstone
+}
+
+// deleteExcluded deletes at the destination what the filters exclude, like
+// rsync's --delete-excluded, whether or not it was synced. Excluded
+// directories go with their contents.
+func (s *syncer) deleteExcluded() {
+	var files, dirs []string
+	excludedDirs := make(map[string]bool)
+	err := fs.WalkDir(s.dst, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		if d.IsDir() && s.inBackupDir(name) {
+			return fs.SkipDir
+		}
+		excluded := excludedDirs[path.Dir(name)]
+		if !excluded && d.Name() == metadataSidecar && s.meta != nil {
+			return nil
+		}
+		if !excluded {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			_, excluded = s.filter.excludeName(name, info)
+		}
+		switch {
+		case !excluded:
+		case d.IsDir():
+			excludedDirs[name] = true
+			dirs = append(dirs, name)
+		default:
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		errorf("Error listing %s for delete_excluded: %v\n", displayPath(s.dst, "."), err)
+		s.stats.addError()
+		return
+	}
+
+	for _, name := range files {
+		destPath := displayPath(s.dst, name)
+		entry := journalEntry{Op: opDelete, Path: name, Backup: s.backupPath(name)}
+		seq := s.journal.begin(entry)
+		if entry.Backup != "" {
+			err = s.moveToBackup(name, entry.Backup)
+		} else {
+			err = s.dst.Remove(name)
+		}
+		s.journal.end(seq, err)
+		if err != nil {
+			errorf("Error deleting excluded %s: %v\n", destPath, err)
+			s.stats.addError()
+			s.report.add(actionFailed, name, 0, 0, err.Error())
+			continue
+		}
+		logf("Deleted excluded %s\n", destPath)
+		s.stats.Deleted.Add(1)
+		s.report.add(actionDeleted, name, 0, 0, tr("excluded"))
+		s.state.forget(name)
+		s.meta.forget(name)
+	}
+
+	// Deepest first, so that each directory is empty by the time it goes
+	for i := len(dirs) - 1; i >= 0; i-- {
+		destPath := displayPath(s.dst, dirs[i])
+		seq := s.journal.begin(journalEntry{Op: opRemoveDir, Path: dirs[i]})
+		err := s.dst.Remove(dirs[i])
+		s.journal.end(seq, err)
+		if err != nil {
+			errorf("Error removing excluded directory %s: %v\n", destPath, err)
+			s.stats.addError()
+			continue
+		}
+		logf("Removed excluded directory %s\n", destPath)
+		s.meta.forget(dirs[i])
+	}
+}
+
+// pruneEmptyDirs removes the empty directories of the destination, deepest
+// first so that directories holding only empty ones go too, keeping the root
+func (s *syncer) pruneEmptyDirs() {
+	var dirs []string
+	err := fs.WalkDir(s.dst, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && name != "." {
+			dirs = append(dirs, name)
+		}
+		return nil
+	})
+	if err != nil {
+		errorf("Error listing directories of %s: %v\n", displayPath(s.dst, "."), err)
+		s.stats.addError()
+		return
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := fs.ReadDir(s.dst, dirs[i])
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		path := displayPath(s.dst, dirs[i])
+		seq := s.journal.begin(journalEntry{Op: opRemoveDir, Path: dirs[i]})
+		err = s.dst.Remove(dirs[i])
+		s.journal.end(seq, err)
+		if err != nil {
+			errorf("Error removing empty directory %s: %v\n", path, err)
+			s.stats.addError()
+			continue
+		}
+		logf("Removed empty directory %s\n", path)
+	}
+}
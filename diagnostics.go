@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+var debugAddr = flag.String("debug-addr", "", "serve net/http/pprof and the state of the run under /debug/ on this address, such as localhost:6060")
+
+// runDiagnostics is what /debug/gosync shows of the run under way, to find
+// out where a run that seems stuck is
+type runDiagnostics struct {
+	mu     sync.Mutex
+	phase  string
+	since  time.Time
+	stats  *Stats
+	queues func() string
+	files  map[int]copyingFile // by worker
+}
+
+// copyingFile is a file a worker is copying
+type copyingFile struct {
+	name  string
+	since time.Time
+}
+
+// diagnostics is only kept up to date with -debug-addr
+var diagnostics *runDiagnostics
+
+// startDiagnostics serves the profiles of net/http/pprof and the state of
+// the run at addr, in the background
+func startDiagnostics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	diagnostics = &runDiagnostics{phase: "starting", since: time.Now(), files: make(map[int]copyingFile)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/gosync", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, diagnostics.String())
+	})
+	go http.Serve(ln, mux)
+	logf("Serving diagnostics on http://%s/debug/gosync and /debug/pprof/\n", ln.Addr())
+	return nil
+}
+
+// start records the run of stats, whose queues describes the channels
+func (d *runDiagnostics) start(stats *Stats, queues func() string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats, d.queues = stats, queues
+}
+
+// setPhase records what the run is doing
+func (d *runDiagnostics) setPhase(phase string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.phase, d.since = phase, time.Now()
+}
+
+// copying records the file worker is copying, none when name is empty
+func (d *runDiagnostics) copying(worker int, name string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if name == "" {
+		delete(d.files, worker)
+	} else {
+		d.files[worker] = copyingFile{name, time.Now()}
+	}
+}
+
+func (d *runDiagnostics) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := fmt.Sprintf("phase: %s (for %s)\n", d.phase, time.Since(d.since).Round(time.Second))
+	if d.queues != nil {
+		s += "queues: " + d.queues() + "\n"
+	}
+	if d.stats != nil {
+		s += fmt.Sprintf("copied %d files (%s), skipped %d, %d errors in %s\n", d.stats.Copied.Load(),
+			formatBytes(d.stats.Bytes.Load()), d.stats.Skipped.Load(), d.stats.Errors.Load(), time.Since(d.stats.Start).Round(time.Second))
+	}
+	workers := make([]int, 0, len(d.files))
+	for worker := range d.files {
+		workers = append(workers, worker)
+	}
+	sort.Ints(workers)
+	for _, worker := range workers {
+		file := d.files[worker]
+		s += fmt.Sprintf("worker %d: copying %s for %s\n", worker, file.name, time.Since(file.since).Round(time.Second))
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s += fmt.Sprintf("goroutines: %d, heap: %s\n", runtime.NumGoroutine(), formatBytes(int64(mem.HeapAlloc)))
+	return s
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Formats of the diff command
+const (
+	diffList = "list"
+	diffTree = "tree"
+)
+
+// Kinds of difference, as marked in the listing
+const (
+	diffOnlyInSource = "+"
+	diffOnlyInDest   = "-"
+	diffDiffers      = "~"
+)
+
+// errDifferences makes the diff command exit with status 1, like diff(1)
+var errDifferences = errors.New("source and destination differ")
+
+// difference is an entry that the next sync would change
+type difference struct {
+	kind string
+	name string
+	dir  bool
+}
+
+// runDiff is the diff command: it lists what differs between the source
+// and the destination, with the filters and comparisons of a sync, without
+// changing anything
+func runDiff(config Config, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := flags.String("format", diffList, "list or tree")
+	flags.Parse(args)
+	if *format != diffList && *format != diffTree {
+		return fmt.Errorf("invalid format %q", *format)
+	}
+
+	src := newOSFS(config.Source)
+	dst := withTargetNames(openDestination(config), config)
+	filter, err := newFilter(config)
+	if err != nil {
+		return err
+	}
+	if len(config.ExcludeCmd) > 0 {
+		if err := filter.runExcludeCmd(src, config.ExcludeCmd); err != nil {
+			return fmt.Errorf("exclude_cmd failed: %v", err)
+		}
+	}
+	var state *State
+	if config.StateFile != "" {
+		if state, err = loadState(config.StateFile); err != nil {
+			return fmt.Errorf("cannot read state file: %v", err)
+		}
+	}
+
+	var diffs []difference
+	inSource := make(map[string]bool)     // destination names
+	excludedDirs := make(map[string]bool) // destination names
+	err = fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		inSource[destName(dst, name)] = true
+		if _, excluded := filter.exclude(name, info); excluded {
+			if d.IsDir() {
+				excludedDirs[destName(dst, name)] = true
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if name == "." {
+			return nil
+		}
+
+		_, statErr := dst.Stat(name)
+		switch {
+		case errors.Is(statErr, fs.ErrNotExist):
+			diffs = append(diffs, difference{diffOnlyInSource, name, d.IsDir()})
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+		case statErr != nil:
+			return statErr
+		case !d.IsDir():
+			needed, _, err := shouldCopy(src, dst, name, config, state)
+			if err != nil {
+				return err
+			}
+			if needed {
+				diffs = append(diffs, difference{diffDiffers, name, false})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// What only the destination has, which excluded entries are not: a
+	// sync leaves them alone unless delete_excluded is set
+	s := &syncer{dst: dst, config: config}
+	err = fs.WalkDir(dst, ".", func(name string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case excludedDirs[name], d.IsDir() && s.inBackupDir(name):
+			return fs.SkipDir
+		case inSource[name], config.MetadataSidecar && d.Name() == metadataSidecar:
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if _, excluded := filter.excludeName(name, info); !excluded {
+			diffs = append(diffs, difference{diffOnlyInDest, name, d.IsDir()})
+		}
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].name < diffs[j].name })
+	if *format == diffTree {
+		printDiffTree(diffs)
+	} else {
+		for _, diff := range diffs {
+			fmt.Println(diff.kind, diff.label(diff.name))
+		}
+	}
+	if len(diffs) > 0 {
+		return errDifferences
+	}
+	return nil
+}
+
+// label is name, marked with a slash when it is a directory
+func (d difference) label(name string) string {
+	if d.dir {
+		return name + "/"
+	}
+	return name
+}
+
+// printDiffTree prints the sorted diffs as an indented tree, showing each
+// directory that holds differences once
+func printDiffTree(diffs []difference) {
+	printed := make(map[string]bool)
+	for _, diff := range diffs {
+		parts := strings.Split(diff.name, "/")
+		for i := 1; i < len(parts); i++ {
+			dir := path.Join(parts[:i]...)
+			if !printed[dir] {
+				fmt.Printf("  %s%s/\n", strings.Repeat("  ", i-1), parts[i-1])
+				printed[dir] = true
+			}
+		}
+		fmt.Printf("%s %s%s\n", diff.kind, strings.Repeat("  ", len(parts)-1), diff.label(parts[len(parts)-1]))
+	}
+}
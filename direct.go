@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"unsafe"
+)
+
+// directAlignment is the alignment of the buffers, offsets and sizes of
+// direct I/O, a multiple of the sector size of the disks
+const directAlignment = 4096
+
+// openSource opens the file name in src, bypassing the page cache with
+// direct_io when the platform and the filesystem allow it
+func openSource(src fs.FS, name string, config Config) (io.ReadCloser, error) {
+	if local, ok := src.(localFS); ok && config.DirectIO {
+		f, err := openDirect(local.localPath(name))
+		if err == nil {
+			return f, nil
+		}
+		debugf("Direct I/O unavailable for %s: %v\n", local.localPath(name), err)
+	}
+	return src.Open(name)
+}
+
+// createDestination creates the file name in dst, bypassing the page cache
+// with direct_io when the platform and the filesystem allow it
+func createDestination(dst DestFS, name string, config Config) (io.WriteCloser, error) {
+	if local, ok := dst.(localFS); ok && config.DirectIO {
+		f, err := createDirect(local.localPath(name))
+		if err == nil {
+			return &directWriter{f: f, buf: alignedBuffer(directAlignment)}, nil
+		}
+		debugf("Direct I/O unavailable for %s: %v\n", local.localPath(name), err)
+	}
+	return dst.Create(name)
+}
+
+// alignedBuffer returns a buffer of size bytes starting at an address
+// aligned for direct I/O
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directAlignment); rem != 0 {
+		offset = directAlignment - rem
+	}
+	return buf[offset : offset+size : offset+size]
+}
+
+// roundUpAligned rounds n up to a multiple of directAlignment
+func roundUpAligned(n int) int {
+	return (n + directAlignment - 1) / directAlignment * directAlignment
+}
+
+// directWriter writes a file opened for direct I/O, which only takes
+// aligned blocks. Writes that are not aligned are gathered into blocks, and
+// the last block is padded then cut back to the real size of the file.
+type directWriter struct {
+	f       *os.File
+	buf     []byte // aligned block being gathered
+	pending int
+	size    int64
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.pending == 0 && n%directAlignment == 0 && uintptr(unsafe.Pointer(unsafe.SliceData(p)))%directAlignment == 0 {
+		if _, err := w.f.Write(p); err != nil {
+			return 0, err
+		}
+		w.size += int64(n)
+		return n, nil
+	}
+
+	for len(p) > 0 {
+		copied := copy(w.buf[w.pending:], p)
+		w.pending += copied
+		p = p[copied:]
+		if w.pending == len(w.buf) {
+			if _, err := w.f.Write(w.buf); err != nil {
+				return 0, err
+			}
+			w.pending = 0
+		}
+	}
+	w.size += int64(n)
+	return n, nil
+}
+
+// flush writes the partial last block, if any. The file can't be written
+// any further afterwards: its end is no longer aligned.
+func (w *directWriter) flush() error {
+	if w.pending == 0 {
+		return nil
+	}
+	clear(w.buf[w.pending:])
+	if _, err := w.f.Write(w.buf); err != nil {
+		return err
+	}
+	w.pending = 0
+	return w.f.Truncate(w.size)
+}
+
+func (w *directWriter) Sync() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *directWriter) Close() error {
+	err := w.flush()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
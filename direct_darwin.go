@@ -0,0 +1,32 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const directIOSupported = true
+
+// openDirect opens path for reading with the cache disabled (F_NOCACHE),
+// the macOS counterpart of O_DIRECT
+func openDirect(path string) (*os.File, error) {
+	return noCache(os.Open(path))
+}
+
+// createDirect creates path for writing with the cache disabled (F_NOCACHE)
+func createDirect(path string) (*os.File, error) {
+	return noCache(os.Create(path))
+}
+
+func noCache(f *os.File, err error) (*os.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_NOCACHE, 1); errno != 0 {
+		f.Close()
+		return nil, errno
+	}
+	return f, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !freebsd && !darwin && !windows
+
+package main
+
+import "os"
+
+// Direct I/O is not implemented on this platform
+const directIOSupported = false
+
+func openDirect(path string) (*os.File, error) {
+	return nil, errUnsupported
+}
+
+func createDirect(path string) (*os.File, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,20 @@
+//go:build linux || freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const directIOSupported = true
+
+// openDirect opens path for reading with O_DIRECT
+func openDirect(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECT, 0)
+}
+
+// createDirect creates path for writing with O_DIRECT
+func createDirect(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_DIRECT, 0666)
+}
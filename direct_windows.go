@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const directIOSupported = true
+
+const fileFlagNoBuffering = 0x20000000
+
+// openDirect opens path for reading with FILE_FLAG_NO_BUFFERING
+func openDirect(path string) (*os.File, error) {
+	return createFile(path, syscall.GENERIC_READ, syscall.OPEN_EXISTING)
+}
+
+// createDirect creates path for writing with FILE_FLAG_NO_BUFFERING
+func createDirect(path string) (*os.File, error) {
+	return createFile(path, syscall.GENERIC_WRITE, syscall.CREATE_ALWAYS)
+}
+
+func createFile(path string, access, disposition uint32) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(name, access, syscall.FILE_SHARE_READ, nil, disposition, syscall.FILE_ATTRIBUTE_NORMAL|fileFlagNoBuffering, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package main
+
+// freeSpace is not implemented on this platform
+func freeSpace(path string) (uint64, error) {
+	return 0, errUnsupported
+}
+
+// freeInodes is not implemented on this platform
+func freeInodes(path string) (uint64, error) {
+	return 0, errUnsupported
+}
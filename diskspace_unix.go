@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// freeInodes returns the number of files that can still be created on the
+// filesystem holding path
+func freeInodes(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	// Filesystems without a fixed inode table, such as btrfs, report none
+	if stat.Files == 0 {
+		return 0, errUnsupported
+	}
+	return uint64(stat.Ffree), nil
+}
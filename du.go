@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// runDu is the du command: it scans the source like a sync does before
+// copying, and prints how many files and bytes each top-level directory
+// would send, largest first
+func runDu(config Config) error {
+	src := newOSFS(config.Source)
+	dst := withTargetNames(openDestination(config), config)
+	filter, err := newFilter(config)
+	if err != nil {
+		return err
+	}
+	if len(config.ExcludeCmd) > 0 {
+		if err := filter.runExcludeCmd(src, config.ExcludeCmd); err != nil {
+			return fmt.Errorf("exclude_cmd failed: %v", err)
+		}
+	}
+
+	scan, err := scanSource(src, dst, config, filter)
+	if err != nil {
+		return err
+	}
+
+	dirs := make([]string, 0, len(scan.Dirs))
+	for dir := range scan.Dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		a, b := scan.Dirs[dirs[i]], scan.Dirs[dirs[j]]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, tr("SIZE\tFILES\tDIRECTORY"))
+	for _, dir := range dirs {
+		label := dir + "/"
+		if dir == "." {
+			label = tr("(files at the root)")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", formatBytes(scan.Dirs[dir].Bytes), scan.Dirs[dir].Files, label)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%s\n", formatBytes(scan.Bytes), scan.Files, tr("total"))
+	return w.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the environment variables setting the options, named
+// after their JSON keys in upper case, as in GOSYNC_WORKER=4
+const envPrefix = "GOSYNC_"
+
+// envNames are the environment variables of the options, by field of
+// Config, empty for the fields without a JSON key. Other GOSYNC_ variables,
+// such as GOSYNC_FAULTS or those given to on_copy, set no option.
+var envNames = func() []string {
+	t := reflect.TypeOf(Config{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		if key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); key != "" && key != "-" {
+			names[i] = envPrefix + strings.ToUpper(key)
+		}
+	}
+	return names
+}()
+
+// envConfigured reports whether any option is set in the environment, in
+// which case config.json may be left out
+func envConfigured() bool {
+	for _, name := range envNames {
+		if _, ok := os.LookupEnv(name); ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnv sets the options of config given in the environment, over those
+// of config.json. Lists take a JSON array or comma-separated values.
+func applyEnv(config *Config) error {
+	v := reflect.ValueOf(config).Elem()
+	for i, name := range envNames {
+		value, ok := os.LookupEnv(name)
+		if !ok || name == "" {
+			continue
+		}
+
+		var err error
+		var expected string
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(value)
+			field.SetBool(b)
+			expected = "true or false"
+		case reflect.Int:
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 0)
+			field.SetInt(n)
+			expected = "an integer"
+		case reflect.Float64:
+			var f float64
+			f, err = strconv.ParseFloat(value, 64)
+			field.SetFloat(f)
+			expected = "a number"
+		case reflect.Slice:
+			var list []string
+			list, err = parseEnvList(value)
+			field.Set(reflect.ValueOf(list))
+			expected = "a JSON array of strings or comma-separated values"
+		default:
+			return fmt.Errorf("%s cannot be set from the environment", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: must be %s", name, value, expected)
+		}
+	}
+	return nil
+}
+
+// parseEnvList parses a list option: a JSON array, which can hold commas,
+// or comma-separated values
+func parseEnvList(value string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		var list []string
+		err := json.Unmarshal([]byte(value), &list)
+		return list, err
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want Config
+	}{
+		{
+			name: "string, integer and bool",
+			env:  map[string]string{"GOSYNC_SOURCE": "/src", "GOSYNC_WORKER": "4", "GOSYNC_CHECKSUM": "true"},
+			want: Config{Source: "/src", Destination: "/dst", Worker: 4, Checksum: true},
+		},
+		{
+			name: "number",
+			env:  map[string]string{"GOSYNC_ANOMALY_FACTOR": "2.5"},
+			want: Config{Destination: "/dst", AnomalyFactor: 2.5},
+		},
+		{
+			name: "comma-separated list",
+			env:  map[string]string{"GOSYNC_SKIP_EXTENSIONS": "pdf, .tar.gz,,"},
+			want: Config{Destination: "/dst", SkipExtensions: []string{"pdf", ".tar.gz"}},
+		},
+		{
+			name: "JSON list",
+			env:  map[string]string{"GOSYNC_SKIP_EXTENSIONS": `["a,b", "c"]`},
+			want: Config{Destination: "/dst", SkipExtensions: []string{"a,b", "c"}},
+		},
+		{
+			name: "empty list",
+			env:  map[string]string{"GOSYNC_SKIP_EXTENSIONS": ""},
+			want: Config{Destination: "/dst", SkipExtensions: []string{}},
+		},
+		{
+			name: "over config.json",
+			env:  map[string]string{"GOSYNC_DESTINATION": "/other"},
+			want: Config{Destination: "/other"},
+		},
+		{
+			name: "variables of no option",
+			env:  map[string]string{"GOSYNC_FAULTS": "copy:a=fail", "GOSYNC_SOURCE_FILE": "/src/a"},
+			want: Config{Destination: "/dst"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config := Config{Destination: "/dst"}
+			if err := applyEnv(&config); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("applyEnv = %+v, want %+v", config, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	for name, value := range map[string]string{
+		"GOSYNC_WORKER":          "four",
+		"GOSYNC_CHECKSUM":        "maybe",
+		"GOSYNC_ANOMALY_FACTOR":  "lots",
+		"GOSYNC_SKIP_EXTENSIONS": `["unterminated`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			var config Config
+			if err := applyEnv(&config); err == nil {
+				t.Errorf("applyEnv with %s=%q succeeded, want an error", name, value)
+			}
+		})
+	}
+}
+
+func TestEnvConfigured(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"GOSYNC_FAULTS": "copy:a=fail"}, false},
+		{map[string]string{"GOSYNC_SOURCE_FILE": "/src/a", "GOSYNC_DEST_FILE": "/dst/a"}, false},
+		{map[string]string{"GOSYNC_WORKER": "4"}, true},
+		{map[string]string{"GOSYNC_DESTINATION": ""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.env), func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if got := envConfigured(); got != tt.want {
+				t.Errorf("envConfigured with %v = %v, want %v", tt.env, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"time"
+)
+
+var eventsFlag = flag.Bool("events", false, "write the events of the run to stdout as versioned JSON lines, the messages going to stderr")
+
+// eventsVersion is the version of the event stream. Fields may be added
+// to the events of a version; removing or changing one, or an event type,
+// takes a new version.
+const eventsVersion = 1
+
+// Types of the events of the stream
+const (
+	eventScanStart = "scan-start"
+	eventPlan      = "plan"
+	eventFileStart = "file-start"
+	eventFileDone  = "file-done"
+	eventError     = "error"
+	eventSummary   = "summary"
+)
+
+// eventHeader starts every event of the stream
+type eventHeader struct {
+	V     int       `json:"v"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+func (h *eventHeader) stamp(event string) {
+	h.V, h.Event, h.Time = eventsVersion, event, time.Now()
+}
+
+// scanStartEvent is sent before the source is scanned
+type scanStartEvent struct {
+	eventHeader
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// planEvent is sent once the run knows what it has to do
+type planEvent struct {
+	eventHeader
+	Files     int64 `json:"files"`     // to copy
+	Bytes     int64 `json:"bytes"`     // to copy
+	Deletions int64 `json:"deletions"` // with propagate_deletes
+	Resumed   bool  `json:"resumed"`   // from the job file, with -resume
+}
+
+// fileStartEvent is sent when the copy of a file starts
+type fileStartEvent struct {
+	eventHeader
+	File string `json:"file"` // slash-separated, relative to the source
+	Size int64  `json:"size"`
+}
+
+// fileDoneEvent is sent when the copy of a file ends, with an error when it
+// failed
+type fileDoneEvent struct {
+	eventHeader
+	File    string  `json:"file"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// errorEvent carries every error message of the run
+type errorEvent struct {
+	eventHeader
+	Message string `json:"message"`
+}
+
+// summaryEvent is the last event of the run
+type summaryEvent struct {
+	eventHeader
+	Copied  int64   `json:"copied"`
+	Skipped int64   `json:"skipped"`
+	Errors  int64   `json:"errors"`
+	Deleted int64   `json:"deleted"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"` // why the run stopped
+}
+
+// emitEvent writes the event e of type event to the stream, with -events
+func emitEvent(event string, e interface{ stamp(string) }) {
+	if !*eventsFlag {
+		return
+	}
+	e.stamp(event)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// emitError sends the error message of errorf
+func emitError(message string) {
+	if *eventsFlag {
+		emitEvent(eventError, &errorEvent{Message: strings.TrimSpace(message)})
+	}
+}
+
+// emitSummary sends the summary of the run, err being why it stopped
+func emitSummary(stats *Stats, err error) {
+	if !*eventsFlag {
+		return
+	}
+	e := &summaryEvent{
+		Copied:  stats.Copied.Load(),
+		Skipped: stats.Skipped.Load(),
+		Errors:  stats.Errors.Load(),
+		Deleted: stats.Deleted.Load(),
+		Bytes:   stats.Bytes.Load(),
+		Seconds: time.Since(stats.Start).Seconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	emitEvent(eventSummary, e)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// errStopListing stops listing the source to a command that exited
+var errStopListing = errors.New("command stopped reading")
+
+// runExcludeCmd lists the source entries to the exclude_cmd command, one
+// path per line on its stdin, and records the paths it prints on its stdout
+// as excluded. Paths are relative to the source root, with slashes.
+func (f *filter) runExcludeCmd(src fs.FS, command []string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Feed the command while reading its answers, which may come at any time
+	listed := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(stdin)
+		err := fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || name == "." {
+				return err
+			}
+			w.WriteString(name)
+			if w.WriteByte('\n') != nil {
+				return errStopListing
+			}
+			return nil
+		})
+		if err == nil && w.Flush() != nil {
+			err = errStopListing
+		}
+		stdin.Close()
+		listed <- err
+	}()
+
+	f.command = make(map[string]bool)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line != "" {
+			f.command[path.Clean(strings.TrimPrefix(line, "./"))] = true
+		}
+	}
+	readErr := scanner.Err()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v", command[0], err)
+	}
+	if readErr != nil {
+		return readErr
+	}
+	// A command that succeeded without reading all the paths is fine
+	if err := <-listed; err != nil && err != errStopListing {
+		return err
+	}
+	return nil
+}
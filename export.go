@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the state DB exports
+const (
+	exportJSON = "json"
+	exportCSV  = "csv"
+)
+
+var errNoStateFile = errors.New("state_file is not set")
+
+// stateRecord is a synced file in an export of the state DB
+type stateRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash,omitempty"`
+	Synced  time.Time `json:"synced"`
+}
+
+var stateCSVHeader = []string{"path", "size", "mtime", "hash", "synced"}
+
+// runExport writes the synced files of the state DB, with their sizes and
+// hashes, to the file given in args or to stdout
+func runExport(config Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "", "json or csv (default from the file extension, else json)")
+	flags.Parse(args)
+	if config.StateFile == "" {
+		return errNoStateFile
+	}
+	kind := exportFormat(*format, flags.Arg(0))
+	if kind != exportJSON && kind != exportCSV {
+		return fmt.Errorf("invalid format %q", kind)
+	}
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return err
+	}
+	records := make([]stateRecord, 0, len(state.Files))
+	for name, file := range state.Files {
+		records = append(records, stateRecord{name, file.Size, file.ModTime, file.Hash, file.Synced})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+
+	out := os.Stdout
+	if flags.NArg() > 0 {
+		if out, err = os.Create(flags.Arg(0)); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	if kind == exportCSV {
+		err = writeStateCSV(out, records)
+	} else {
+		err = json.NewEncoder(out).Encode(records)
+	}
+	if err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		logf("Exported %d files to %s\n", len(records), flags.Arg(0))
+		return out.Close()
+	}
+	return nil
+}
+
+// runImport merges an export of a state DB, typically produced on another
+// machine, into the state DB, replacing the records of the same files
+func runImport(config Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "json or csv (default from the file extension, else json)")
+	flags.Parse(args)
+	if config.StateFile == "" {
+		return errNoStateFile
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [-format json|csv] file")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records []stateRecord
+	switch exportFormat(*format, flags.Arg(0)) {
+	case exportJSON:
+		err = json.NewDecoder(f).Decode(&records)
+	case exportCSV:
+		records, err = readStateCSV(f)
+	default:
+		return fmt.Errorf("invalid format %q", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid export %s: %v", flags.Arg(0), err)
+	}
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if !fs.ValidPath(record.Path) {
+			return fmt.Errorf("invalid path %q in %s", record.Path, flags.Arg(0))
+		}
+		state.Files[record.Path] = FileState{Size: record.Size, ModTime: record.ModTime, Synced: record.Synced, Hash: record.Hash}
+		delete(state.Tombstones, record.Path)
+	}
+	if err := state.save(config.StateFile); err != nil {
+		return err
+	}
+	logf("Imported %d files into %s\n", len(records), config.StateFile)
+	return nil
+}
+
+// exportFormat returns the format given, or the one of the file extension
+func exportFormat(format, file string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return exportCSV
+	}
+	return exportJSON
+}
+
+func writeStateCSV(w io.Writer, records []stateRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write(stateCSVHeader)
+	for _, r := range records {
+		cw.Write([]string{
+			r.Path,
+			strconv.FormatInt(r.Size, 10),
+			r.ModTime.Format(time.RFC3339Nano),
+			r.Hash,
+			r.Synced.Format(time.RFC3339Nano),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readStateCSV(r io.Reader) ([]stateRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(stateCSVHeader)
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	line := 1
+	if len(rows) > 0 && rows[0][0] == stateCSVHeader[0] {
+		rows = rows[1:]
+		line++
+	}
+
+	records := make([]stateRecord, 0, len(rows))
+	for i, row := range rows {
+		record := stateRecord{Path: row[0], Hash: row[3]}
+		var sizeErr, mtimeErr, syncedErr error
+		record.Size, sizeErr = strconv.ParseInt(row[1], 10, 64)
+		record.ModTime, mtimeErr = time.Parse(time.RFC3339Nano, row[2])
+		record.Synced, syncedErr = time.Parse(time.RFC3339Nano, row[4])
+		if err := errors.Join(sizeErr, mtimeErr, syncedErr); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+i, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faultsEnv enables fault injection, so that the error handling of the copy
+// can be exercised in integration tests. It holds comma-separated settings:
+//
+//	read=0.01   probability of failing a read
+//	write=0.01  probability of failing a write
+//	slow=0.05   probability of stalling an operation for delay
+//	crash=0.001 probability of killing the process in the middle of an operation
+//	delay=500ms duration of a stall (default 1s)
+//	seed=42     seed of the random generator, for reproducible runs
+const faultsEnv = "GOSYNC_FAULTS"
+
+// exitCrash is the exit status of an injected crash
+const exitCrash = 70
+
+var errInjected = errors.New("injected fault")
+
+// faults is the active fault injection, nil unless faultsEnv is set
+var faults = loadFaults()
+
+// faultInjector randomly fails, stalls or crashes I/O operations
+type faultInjector struct {
+	read, write, slow, crash float64
+	delay                    time.Duration
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func loadFaults() *faultInjector {
+	spec := os.Getenv(faultsEnv)
+	if spec == "" {
+		return nil
+	}
+
+	f, err := parseFaults(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid %s: %v\n", faultsEnv, err)
+		os.Exit(2)
+	}
+	fmt.Fprintf(os.Stderr, "Fault injection enabled: %s\n", spec)
+	return f
+}
+
+func parseFaults(spec string) (*faultInjector, error) {
+	f := &faultInjector{delay: time.Second}
+	seed := time.Now().UnixNano()
+
+	for _, setting := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return nil, fmt.Errorf("missing value in %q", setting)
+		}
+
+		var err error
+		switch key {
+		case "read":
+			f.read, err = strconv.ParseFloat(value, 64)
+		case "write":
+			f.write, err = strconv.ParseFloat(value, 64)
+		case "slow":
+			f.slow, err = strconv.ParseFloat(value, 64)
+		case "crash":
+			f.crash, err = strconv.ParseFloat(value, 64)
+		case "delay":
+			f.delay, err = time.ParseDuration(value)
+		case "seed":
+			seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	f.rng = rand.New(rand.NewSource(seed))
+	return f, nil
+}
+
+func (f *faultInjector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < p
+}
+
+// inject runs before an operation, returning an error when it must fail
+func (f *faultInjector) inject(op string, p float64) error {
+	if f.chance(f.crash) {
+		fmt.Fprintf(os.Stderr, "Injected crash during %s\n", op)
+		os.Exit(exitCrash)
+	}
+	if f.chance(f.slow) {
+		time.Sleep(f.delay)
+	}
+	if f.chance(p) {
+		return fmt.Errorf("%s: %w", op, errInjected)
+	}
+	return nil
+}
+
+// reader wraps r with fault injection, or returns it as is when disabled
+func (f *faultInjector) reader(r io.Reader) io.Reader {
+	if f == nil {
+		return r
+	}
+	return &faultyReader{r: r, faults: f}
+}
+
+// writer wraps w with fault injection, or returns it as is when disabled
+func (f *faultInjector) writer(w io.Writer) io.Writer {
+	if f == nil {
+		return w
+	}
+	return &faultyWriter{w: w, faults: f}
+}
+
+type faultyReader struct {
+	r      io.Reader
+	faults *faultInjector
+}
+
+func (r *faultyReader) Read(p []byte) (int, error) {
+	if err := r.faults.inject("read", r.faults.read); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+type faultyWriter struct {
+	w      io.Writer
+	faults *faultInjector
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	if err := w.faults.inject("write", w.faults.write); err != nil {
+		// Fail halfway through, like a real short write
+		n, _ := w.w.Write(p[:len(p)/2])
+		return n, err
+	}
+	return w.w.Write(p)
+}
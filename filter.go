@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// filter decides which source entries are left out of the sync
+type filter struct {
+	extensions []string
+	owners     map[uint32]bool
+	groups     map[uint32]bool
+	command    map[string]bool // excluded by exclude_cmd
+	sidecars   bool            // metadata_sidecar, whose files are not synced
+	rules      []filterRule    // from include_from and exclude_from
+}
+
+func newFilter(config Config) (*filter, error) {
+	f := &filter{extensions: config.SkipExtensions, sidecars: config.MetadataSidecar}
+	var err error
+	if f.rules, err = loadFilterRules(config); err != nil {
+		return nil, err
+	}
+	if len(config.SkipOwners) == 0 && len(config.SkipGroups) == 0 {
+		return f, nil
+	}
+	// Refuse to run rather than let data out that was meant to stay
+	if !ownersSupported {
+		return nil, fmt.Errorf("skip_owners and skip_groups are %v", errUnsupported)
+	}
+
+	if f.owners, err = lookupIDs(config.SkipOwners, lookupUserID); err != nil {
+		return nil, fmt.Errorf("skip_owners: %v", err)
+	}
+	if f.groups, err = lookupIDs(config.SkipGroups, lookupGroupID); err != nil {
+		return nil, fmt.Errorf("skip_groups: %v", err)
+	}
+	return f, nil
+}
+
+// exclude returns why the source entry name, described by info, is left out.
+// Excluding a directory excludes everything below it.
+func (f *filter) exclude(name string, info fs.FileInfo) (string, bool) {
+	if f.sidecars && path.Base(name) == metadataSidecar {
+		return tr("metadata sidecar"), true
+	}
+	if reason, ok := f.excludeName(name, info); ok {
+		return reason, true
+	}
+
+	if len(f.owners) > 0 || len(f.groups) > 0 {
+		if uid, gid, ok := fileOwner(info); ok {
+			if f.owners[uid] {
+				return fmt.Sprintf(tr("owned by user %d, which is in skip_owners"), uid), true
+			}
+			if f.groups[gid] {
+				return fmt.Sprintf(tr("owned by group %d, which is in skip_groups"), gid), true
+			}
+		}
+	}
+	return "", false
+}
+
+// excludeName is exclude without skip_owners and skip_groups, for entries
+// of the destination, whose owners are not those of the source
+func (f *filter) excludeName(name string, info fs.FileInfo) (string, bool) {
+	if f.command[name] {
+		return tr("excluded by exclude_cmd"), true
+	}
+	// The first matching rule decides, and an include only overrides the
+	// rules after it, not skip_extensions and the others
+	if rule, ok := matchRules(f.rules, name, info); ok && !rule.include {
+		return fmt.Sprintf(tr("matches %q in %s"), rule.pattern, rule.file), true
+	}
+
+	if !info.IsDir() {
+		if ext, ok := matchExtension(name, f.extensions); ok {
+			return fmt.Sprintf(tr("extension %s is in skip_extensions"), ext), true
+		}
+	}
+	return "", false
+}
+
+// matchExtension returns the first of extensions that path ends with.
+// Extensions match case-insensitively, with or without their leading dot,
+// and may be compound, such as ".tar.gz".
+func matchExtension(path string, extensions []string) (string, bool) {
+	base := strings.ToLower(filepath.Base(path))
+	for _, ext := range extensions {
+		suffix := "." + strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if suffix != "." && strings.HasSuffix(base, suffix) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+// lookupIDs resolves user or group names, or numeric ids, to ids
+func lookupIDs(names []string, lookup func(string) (string, error)) (map[uint32]bool, error) {
+	ids := make(map[uint32]bool, len(names))
+	for _, name := range names {
+		id := name
+		if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+			if id, err = lookup(name); err != nil {
+				return nil, err
+			}
+		}
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s has no numeric id", name)
+		}
+		ids[uint32(n)] = true
+	}
+	return ids, nil
+}
+
+func lookupUserID(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroupID(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Rule files given on the command line, after those of the config
+var includeFrom, excludeFrom []string
+
+func init() {
+	flag.Func("include-from", "read include rules from an rsync filter file (repeatable)", func(file string) error {
+		includeFrom = append(includeFrom, file)
+		return nil
+	})
+	flag.Func("exclude-from", "read exclude rules from an rsync filter file (repeatable)", func(file string) error {
+		excludeFrom = append(excludeFrom, file)
+		return nil
+	})
+}
+
+// filterRule is one line of an rsync include or exclude file
+type filterRule struct {
+	include bool
+	pattern string
+	file    string
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// loadFilterRules reads the rules of include_from and then exclude_from,
+// in order. Plain lines take the kind of their file; lines starting with
+// "+ " or "- " are includes or excludes wherever they are, and a line with
+// only "!" drops the rules read so far, as rsync does.
+func loadFilterRules(config Config) ([]filterRule, error) {
+	var rules []filterRule
+	for _, from := range []struct {
+		files   []string
+		include bool
+	}{{config.IncludeFrom, true}, {config.ExcludeFrom, false}} {
+		for _, file := range from.files {
+			var err error
+			if rules, err = readFilterFile(rules, file, from.include); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rules, nil
+}
+
+func readFilterFile(rules []filterRule, file string, include bool) ([]filterRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line == "!" {
+			rules = rules[:0]
+			continue
+		}
+		rule := filterRule{include: include, file: file}
+		if strings.HasPrefix(line, "+ ") {
+			rule.include, line = true, line[2:]
+		} else if strings.HasPrefix(line, "- ") {
+			rule.include, line = false, line[2:]
+		}
+		if rule.re, rule.dirOnly, err = compileFilterPattern(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// compileFilterPattern turns an rsync pattern into a regexp of the path
+// relative to the source. A leading "/" anchors the pattern to the source,
+// a trailing "/" matches only directories, and a pattern without any other
+// "/" or "**" matches the last name of the path. "*" and "?" stop at "/",
+// "**" does not, and "dir/***" matches dir and everything below it.
+func compileFilterPattern(pattern string) (*regexp.Regexp, bool, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return nil, false, fmt.Errorf("empty pattern")
+	}
+	contents := strings.HasSuffix(pattern, "/***")
+	pattern = strings.TrimSuffix(pattern, "/***")
+
+	var expr strings.Builder
+	if anchored {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("(^|/)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, false, fmt.Errorf("unterminated [ in %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if contents {
+		expr.WriteString("(/.*)?")
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	return re, dirOnly, err
+}
+
+// matchRules returns the first rule that matches the entry, if any
+func matchRules(rules []filterRule, name string, info fs.FileInfo) (filterRule, bool) {
+	for _, rule := range rules {
+		if rule.dirOnly && !info.IsDir() {
+			continue
+		}
+		if rule.re.MatchString(name) {
+			return rule, true
+		}
+	}
+	return filterRule{}, false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileFilterPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		dir     bool
+		want    bool
+	}{
+		// Without a "/", the last name of the path
+		{"*.log", "x.log", false, true},
+		{"*.log", "a/b/x.log", false, true},
+		{"*.log", "a/x.logs", false, false},
+		{"file?.txt", "file1.txt", false, true},
+		{"file?.txt", "file12.txt", false, false},
+
+		// Anchored to the source
+		{"/top/*.txt", "top/a.txt", false, true},
+		{"/top/*.txt", "x/top/a.txt", false, false},
+		{"/top/*.txt", "top/a/b.txt", false, false},
+
+		// "**" crosses folders, "***" takes a folder and its contents
+		{"a/**/z", "a/b/c/z", false, true},
+		{"a/**/z", "b/a/z/z", false, true},
+		{"dir/***", "dir", true, true},
+		{"dir/***", "dir/x/y", false, true},
+		{"dir/***", "dirx", false, false},
+
+		// Directories only
+		{"cache/", "a/cache", true, true},
+		{"cache/", "a/cache", false, false},
+
+		// Classes and escapes
+		{"[!a]*", "b", false, true},
+		{"[!a]*", "a", false, false},
+		{"[ab].txt", "b.txt", false, true},
+		{`\*`, "*", false, true},
+		{`\*`, "x", false, false},
+		{"a.b", "axb", false, false},
+	}
+	for _, tt := range tests {
+		re, dirOnly, err := compileFilterPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compileFilterPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		rules := []filterRule{{pattern: tt.pattern, dirOnly: dirOnly, re: re}}
+		if _, got := matchRules(rules, tt.name, &rcloneInfo{Base: filepath.Base(tt.name), Folder: tt.dir}); got != tt.want {
+			t.Errorf("%q matching %q (dir %v) = %v, want %v", tt.pattern, tt.name, tt.dir, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"", "/", "[abc"} {
+		if _, _, err := compileFilterPattern(pattern); err == nil {
+			t.Errorf("compileFilterPattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestReadFilterFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules")
+	content := "# comment\n; comment\n*.tmp\n!\n+ keep.log\n*.log\r\n\n- /build/\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := readFilterFile(nil, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		pattern string
+		include bool
+	}{{"keep.log", true}, {"*.log", false}, {"/build/", false}}
+	if len(rules) != len(want) {
+		t.Fatalf("read %d rules, want %d", len(rules), len(want))
+	}
+	for i, rule := range rules {
+		if rule.pattern != want[i].pattern || rule.include != want[i].include {
+			t.Errorf("rule %d = %q include %v, want %q include %v", i, rule.pattern, rule.include, want[i].pattern, want[i].include)
+		}
+	}
+
+	// The first matching rule wins
+	for _, tt := range []struct {
+		name    string
+		include bool
+	}{{"a/keep.log", true}, {"a/other.log", false}} {
+		rule, ok := matchRules(rules, tt.name, &rcloneInfo{Base: filepath.Base(tt.name)})
+		if !ok || rule.include != tt.include {
+			t.Errorf("%q matched %q include %v, want include %v", tt.name, rule.pattern, rule.include, tt.include)
+		}
+	}
+	if _, ok := matchRules(rules, "a/x.tmp", &rcloneInfo{Base: "x.tmp"}); ok {
+		t.Errorf("a/x.tmp matched a rule dropped by !")
+	}
+
+	if err := os.WriteFile(file, []byte("ok\n[bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFilterFile(nil, file, true); err == nil {
+		t.Errorf("readFilterFile succeeded on an unterminated [, want an error")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"path"
+)
+
+// flushFile makes the copy of name in dst durable: the data of w is flushed
+// to the disk, then the directory holding the new entry
+func flushFile(dst DestFS, name string, w io.Writer) error {
+	if f, ok := w.(interface{ Sync() error }); ok {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return flushParent(dst, name)
+}
+
+// flushParent flushes the directory containing name to the disk, so that
+// the entry of name survives a power loss or an unplugged drive
+func flushParent(dst DestFS, name string) error {
+	local, ok := dst.(localFS)
+	if !ok {
+		return nil
+	}
+	return syncDir(local.localPath(path.Dir(name)))
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// syncDir flushes the entries of the directory dir to the disk
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
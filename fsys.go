@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DestFS is the side written by a sync, readable back for comparisons.
+// Names are slash-separated paths relative to its root, as in io/fs; the
+// source side is a plain fs.FS.
+type DestFS interface {
+	fs.FS
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	Create(name string) (io.WriteCloser, error)
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Remove(name string) error
+}
+
+// localFS is implemented by filesystems backed by a directory on disk,
+// which platform-specific metadata (alternate data streams, creation time)
+// needs to reach
+type localFS interface {
+	localPath(name string) string
+}
+
+// osFS is a directory on disk, readable as an fs.FS and writable as a DestFS
+type osFS struct {
+	fs.FS
+	root string
+}
+
+func newOSFS(root string) osFS {
+	return osFS{FS: os.DirFS(root), root: root}
+}
+
+func (f osFS) localPath(name string) string {
+	return filepath.Join(f.root, filepath.FromSlash(name))
+}
+
+func (f osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(f.localPath(name))
+}
+
+func (f osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(f.localPath(name), perm)
+}
+
+func (f osFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(f.localPath(name))
+}
+
+func (f osFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(f.localPath(name), mode)
+}
+
+func (f osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(f.localPath(name), atime, mtime)
+}
+
+func (f osFS) Remove(name string) error {
+	return os.Remove(f.localPath(name))
+}
+
+// openDestination returns the destination of config: a directory, or a
+// remote of rclone when it starts with rclonePrefix
+func openDestination(config Config) DestFS {
+	if remote, ok := strings.CutPrefix(config.Destination, rclonePrefix); ok {
+		return newRcloneFS(config.RclonePath, remote)
+	}
+	return newOSFS(config.Destination)
+}
+
+// displayPath returns the path of name to show in messages and logs
+func displayPath(fsys any, name string) string {
+	if local, ok := fsys.(localFS); ok {
+		return local.localPath(name)
+	}
+	if remote, ok := fsys.(*rcloneFS); ok {
+		return remote.path(name)
+	}
+	return name
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcGracePeriod protects the chunks of a run still in progress, which no
+// snapshot lists yet
+const gcGracePeriod = time.Hour
+
+// runGC is the gc command: it removes what the retention policy no longer
+// keeps, the expired run folders of backup_dir or the expired snapshots of
+// a chunk store, then the chunks no snapshot refers to. With -dry-run, it
+// only reports the space it would reclaim.
+func runGC(config Config, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be removed")
+	flags.Parse(args)
+
+	remove := func(path string) error {
+		if *dryRun {
+			debugf("Would remove %s\n", path)
+			return nil
+		}
+		debugf("Removing %s\n", path)
+		return os.RemoveAll(path)
+	}
+
+	var removed int
+	var reclaimed int64
+	switch {
+	case config.DestinationFormat == formatChunks:
+		store, err := openChunkStore(config.Destination, config.CompressionLevel)
+		if err != nil {
+			return err
+		}
+		snapshots, chunks, bytes, err := store.collect(config, remove)
+		if err != nil {
+			return err
+		}
+		removed, reclaimed = snapshots+chunks, bytes
+		logf("%d expired snapshots, %d unreferenced chunks\n", snapshots, chunks)
+	case config.BackupDir != "" && config.versionedBackups():
+		s := &syncer{dst: newOSFS(config.Destination), config: config}
+		expired, err := expiredBackups(s.backupRoot(), config)
+		if err != nil {
+			return err
+		}
+		for _, path := range expired {
+			reclaimed += treeSize(path)
+			if err := remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		logf("%d expired backups\n", removed)
+	default:
+		return fmt.Errorf("nothing to collect: set destination_format chunks, or backup_dir with a retention policy")
+	}
+
+	if *dryRun {
+		logf("Would reclaim %s by removing %d entries\n", formatBytes(reclaimed), removed)
+	} else {
+		logf("Reclaimed %s by removing %d entries\n", formatBytes(reclaimed), removed)
+	}
+	return nil
+}
+
+// collect removes the snapshots the retention policy expires, when there is
+// one, then the chunks that no snapshot left refers to, older than the
+// grace period. It returns the number of each and the bytes they took.
+func (c *chunkStore) collect(config Config, remove func(path string) error) (int, int, int64, error) {
+	ids, err := c.snapshots()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	keep := make(map[string]bool)
+	for _, id := range ids {
+		keep[id] = true
+	}
+	if config.versionedBackups() {
+		keep = retainedRuns(ids, config, time.Now())
+	}
+
+	var snapshots, chunks int
+	var bytes int64
+	referenced := make(map[string]bool)
+	for _, id := range ids {
+		path := filepath.Join(c.root, snapshotsDir, id+".json")
+		if !keep[id] {
+			bytes += treeSize(path)
+			if err := remove(path); err != nil {
+				return snapshots, chunks, bytes, err
+			}
+			snapshots++
+			continue
+		}
+		snap, err := c.loadSnapshot(id)
+		if err != nil {
+			return snapshots, chunks, bytes, err
+		}
+		for _, entry := range snap.Files {
+			for _, chunk := range entry.Chunks {
+				referenced[chunk] = true
+			}
+		}
+	}
+
+	cutoff := time.Now().Add(-gcGracePeriod)
+	err = filepath.WalkDir(filepath.Join(c.root, chunksDir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if referenced[strings.TrimSuffix(d.Name(), gzipSuffix)] || info.ModTime().After(cutoff) {
+			return nil
+		}
+		bytes += info.Size()
+		chunks++
+		return remove(path)
+	})
+	return snapshots, chunks, bytes, err
+}
+
+// treeSize is the size of the files at path, a file or a folder
+func treeSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Hash algorithms for checksum and verification
+const (
+	hashSHA256 = "sha256"
+	hashXXH64  = "xxh64"
+	hashBLAKE3 = "blake3"
+)
+
+// hashAlgorithms lists the algorithms with their display names, fastest last
+var hashAlgorithms = []struct{ name, label string }{
+	{hashSHA256, "SHA-256"},
+	{hashBLAKE3, "BLAKE3"},
+	{hashXXH64, "xxHash64"},
+}
+
+// newHash returns a new hash of the algorithm, which must be valid
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case hashXXH64:
+		return newXXH64()
+	case hashBLAKE3:
+		return newBLAKE3()
+	default:
+		return sha256.New()
+	}
+}
+
+// hashFile returns the hash of the file name in fsys
+func hashFile(fsys fs.FS, name, algorithm string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash(algorithm)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// sameHash reports whether the file name has the same content in src and dst
+func sameHash(src fs.FS, dst DestFS, name, algorithm string) (bool, error) {
+	sourceHash, err := hashFile(src, name, algorithm)
+	if err != nil {
+		return false, err
+	}
+	destHash, err := hashFile(dst, name, algorithm)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sourceHash, destHash), nil
+}
+
+// sameChecksum reports whether the file name is already at the destination
+// with the same size and hash, whatever its modification time. The hash of
+// the destination recorded in state is used when the file is unchanged since,
+// sparing a read of the destination; the source hash is recorded when equal.
+func sameChecksum(src fs.FS, dst DestFS, name, algorithm string, state *State) (bool, error) {
+	sourceInfo, err := fs.Stat(src, name)
+	if err != nil {
+		return false, err
+	}
+	destInfo, err := dst.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil || sourceInfo.Size() != destInfo.Size() {
+		return false, err
+	}
+
+	sourceHash, err := hashFile(src, name, algorithm)
+	if err != nil {
+		return false, err
+	}
+	destHash, ok := state.storedHash(name, destInfo, modifyWindow(dst), algorithm)
+	if !ok {
+		if destHash, err = hashFile(dst, name, algorithm); err != nil {
+			return false, err
+		}
+	}
+
+	if !bytes.Equal(sourceHash, destHash) {
+		return false, nil
+	}
+	state.recordSynced(name, sourceInfo, formatHash(algorithm, sourceHash))
+	return true, nil
+}
+
+// formatHash returns sum as recorded in the state DB
+func formatHash(algorithm string, sum []byte) string {
+	return algorithm + ":" + hex.EncodeToString(sum)
+}
+
+// parseHash returns the sum of a hash recorded in the state DB, if it was
+// computed with the algorithm
+func parseHash(recorded, algorithm string) ([]byte, bool) {
+	prefix, digits, ok := strings.Cut(recorded, ":")
+	if !ok || prefix != algorithm {
+		return nil, false
+	}
+	sum, err := hex.DecodeString(digits)
+	return sum, err == nil
+}
+
+// verifyCopy checks that the file name has the same content in src and dst
+func verifyCopy(src fs.FS, dst DestFS, name, algorithm string) error {
+	same, err := sameHash(src, dst, name, algorithm)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("content differs from the source")
+	}
+	return nil
+}
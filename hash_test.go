@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+// blake3Input is the input of the official BLAKE3 test vectors: the bytes
+// 0, 1, ..., 250, 0, 1, ... up to n
+func blake3Input(n int) []byte {
+	input := make([]byte, n)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	return input
+}
+
+func TestKnownHashes(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		input     []byte
+		want      string
+	}{
+		// From the xxHash reference implementation, seed 0
+		{hashXXH64, []byte(""), "ef46db3751d8e999"},
+		{hashXXH64, []byte("abc"), "44bc2cf5ad770999"},
+		{hashXXH64, []byte("Nobody inspects the spammish repetition"), "fbcea83c8a378bf1"},
+
+		// From test_vectors.json of the BLAKE3 reference implementation,
+		// spanning one chunk of 1024 bytes, several, and trees of chunks
+		{hashBLAKE3, blake3Input(0), "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{hashBLAKE3, blake3Input(1), "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{hashBLAKE3, blake3Input(1023), "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+		{hashBLAKE3, blake3Input(1024), "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{hashBLAKE3, blake3Input(1025), "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{hashBLAKE3, blake3Input(2048), "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+		{hashBLAKE3, blake3Input(2049), "5f4d72f40d7a5f82b15ca2b2e44b1de3c2ef86c426c95c1af0b6879522563030"},
+		{hashBLAKE3, blake3Input(3072), "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+		{hashBLAKE3, blake3Input(3073), "7124b49501012f81cc7f11ca069ec9226cecb8a2c850cfe644e327d22d3e1cd3"},
+		{hashBLAKE3, blake3Input(4096), "015094013f57a5277b59d8475c0501042c0b642e531b0a1c8f58d2163229e969"},
+		{hashBLAKE3, blake3Input(4097), "9b4052b38f1c5fc8b1f9ff7ac7b27cd242487b3d890d15c96a1c25b8aa0fb995"},
+		{hashBLAKE3, blake3Input(8192), "aae792484c8efe4f19e2ca7d371d8c467ffb10748d8a5a1ae579948f718a2a63"},
+		{hashBLAKE3, blake3Input(8193), "bab6c09cb8ce8cf459261398d2e7aef35700bf488116ceb94a36d0f5f1b7bc3b"},
+		{hashBLAKE3, blake3Input(102400), "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+	}
+	for _, tt := range tests {
+		// At once, and in writes that split the blocks, chunks and stripes
+		for _, size := range []int{len(tt.input), 1, 7, 63, 1000} {
+			if size == 0 {
+				size = 1
+			}
+			h := newHash(tt.algorithm)
+			write(h, tt.input, size)
+			if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+				t.Errorf("%s of %d bytes in writes of %d = %s, want %s", tt.algorithm, len(tt.input), size, got, tt.want)
+			}
+		}
+
+		// Reset starts over, and Sum leaves the state as it is
+		h := newHash(tt.algorithm)
+		h.Write([]byte("something else"))
+		h.Reset()
+		h.Write(tt.input)
+		h.Sum(nil)
+		if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+			t.Errorf("%s of %d bytes after Reset = %s, want %s", tt.algorithm, len(tt.input), got, tt.want)
+		}
+	}
+}
+
+// write writes p to h size bytes at a time
+func write(h hash.Hash, p []byte, size int) {
+	for len(p) > 0 {
+		n := min(size, len(p))
+		h.Write(p[:n])
+		p = p[n:]
+	}
+}
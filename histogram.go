@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the histogram buckets; a last bucket holds everything above
+var (
+	sizeBounds  = []int64{4 << 10, 64 << 10, 1 << 20, 16 << 20, 256 << 20, 4 << 30}
+	speedBounds = []int64{1 << 20, 10 << 20, 100 << 20, 1000 << 20} // bytes per second
+)
+
+// histogramWidth is the length of the longest bar
+const histogramWidth = 30
+
+// histogram counts copied files, and their bytes, per bucket
+type histogram struct {
+	bounds []int64
+	files  []atomic.Int64
+	bytes  []atomic.Int64
+}
+
+func newHistogram(bounds []int64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		files:  make([]atomic.Int64, len(bounds)+1),
+		bytes:  make([]atomic.Int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) add(value, bytes int64) {
+	i := 0
+	for i < len(h.bounds) && value >= h.bounds[i] {
+		i++
+	}
+	h.files[i].Add(1)
+	h.bytes[i].Add(bytes)
+}
+
+// format renders the histogram, labelling the bounds with unit
+func (h *histogram) format(title, unit string) string {
+	var maxFiles int64
+	for i := range h.files {
+		maxFiles = max(maxFiles, h.files[i].Load())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:\n", tr(title))
+	for i := range h.files {
+		var label string
+		switch {
+		case i == 0:
+			label = "< " + formatBytes(h.bounds[0]) + unit
+		case i == len(h.bounds):
+			label = ">= " + formatBytes(h.bounds[i-1]) + unit
+		default:
+			label = formatBytes(h.bounds[i-1]) + " - " + formatBytes(h.bounds[i]) + unit
+		}
+
+		files := h.files[i].Load()
+		bar := 0
+		if maxFiles > 0 {
+			bar = int(files * histogramWidth / maxFiles)
+		}
+		line := fmt.Sprintf(tr("  %-26s %8d files %12s  %s"), label, files, formatBytes(h.bytes[i].Load()), strings.Repeat("#", bar))
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return b.String()
+}
+
+// addTransfer records a copied file in the histograms
+func (s *Stats) addTransfer(bytes int64, duration time.Duration) {
+	s.sizes.add(bytes, bytes)
+	if seconds := duration.Seconds(); seconds > 0 {
+		s.speeds.add(int64(float64(bytes)/seconds), bytes)
+	} else {
+		s.speeds.add(speedBounds[len(speedBounds)-1], bytes)
+	}
+}
+
+// Histogram returns the file size and throughput histograms of the copied files
+func (s *Stats) Histogram() string {
+	return s.sizes.format("File sizes", "") + s.speeds.format("Throughput per file", "/s")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Ways of passing the copied file to the on_copy command
+const (
+	hookInputArg  = "arg"
+	hookInputJSON = "json"
+)
+
+// hookEvent describes a copied file to the on_copy command, on its stdin
+type hookEvent struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Path        string    `json:"path"` // relative to the source and destination roots
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+}
+
+// runHook runs the on_copy command for the file name, copied from source
+// to destination, passing it as the last argument or as JSON on stdin. The
+// paths are also set in GOSYNC_SOURCE_FILE and GOSYNC_DEST_FILE.
+func runHook(config Config, name, source, destination string, info fs.FileInfo) error {
+	args := config.OnCopy[1:]
+	var stdin []byte
+	if config.OnCopyInput == hookInputJSON {
+		event, _ := json.Marshal(hookEvent{source, destination, name, info.Size(), info.ModTime()})
+		stdin = append(event, '\n')
+	} else {
+		args = append(args[:len(args):len(args)], destination)
+	}
+
+	cmd := exec.Command(config.OnCopy[0], args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = messageOutput()
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "GOSYNC_SOURCE_FILE="+source, "GOSYNC_DEST_FILE="+destination)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", config.OnCopy[0], err)
+	}
+	return nil
+}
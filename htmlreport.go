@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"time"
+)
+
+// Size of the throughput chart of the html_report, in pixels; runs with
+// more samples than chartWidth are averaged down to one point per pixel
+const (
+	chartWidth  = 800
+	chartHeight = 200
+)
+
+// htmlReport is what the html_report template shows
+type htmlReport struct {
+	Title    string
+	Summary  string
+	Rows     [][2]string
+	Chart    string // SVG points of the throughput
+	Peak     string
+	Failures []reportEntry
+}
+
+// writeHTMLReport writes the report of a run to path, as a single HTML
+// file that needs nothing else to display, to be sent by email
+func writeHTMLReport(path string, stats *Stats, rates []float64, failures []reportEntry) error {
+	elapsed := time.Since(stats.Start)
+	report := htmlReport{
+		Title:   fmt.Sprintf(tr("GoSync run of %s"), stats.Start.Format("2006-01-02 15:04")),
+		Summary: stats.Summary(),
+		Rows: [][2]string{
+			{tr("Files copied"), fmt.Sprint(stats.Copied.Load())},
+			{tr("Bytes copied"), formatBytes(stats.Bytes.Load())},
+			{tr("Files skipped"), fmt.Sprint(stats.Skipped.Load())},
+			{tr("Files deleted"), fmt.Sprint(stats.Deleted.Load())},
+			{tr("Errors"), fmt.Sprint(stats.Errors.Load())},
+			{tr("Duration"), elapsed.Round(time.Second).String()},
+			{tr("Average throughput"), formatBytes(int64(float64(stats.Bytes.Load())/max(elapsed.Seconds(), 1))) + "/s"},
+		},
+		Failures: failures,
+	}
+	report.Chart, report.Peak = chartPoints(rates)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := htmlTemplate.Execute(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// chartPoints returns the SVG polyline of rates, scaled to the chart, and
+// the peak rate at its top
+func chartPoints(rates []float64) (string, string) {
+	if len(rates) > chartWidth {
+		averaged := make([]float64, chartWidth)
+		for i := range averaged {
+			bucket := rates[i*len(rates)/chartWidth : (i+1)*len(rates)/chartWidth]
+			for _, rate := range bucket {
+				averaged[i] += rate / float64(len(bucket))
+			}
+		}
+		rates = averaged
+	}
+	if len(rates) < 2 {
+		return "", ""
+	}
+
+	peak := 0.0
+	for _, rate := range rates {
+		peak = max(peak, rate)
+	}
+	if peak == 0 {
+		peak = 1
+	}
+	var points strings.Builder
+	for i, rate := range rates {
+		x := float64(i) * chartWidth / float64(len(rates)-1)
+		y := chartHeight - rate/peak*chartHeight
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y)
+	}
+	return points.String(), formatBytes(int64(peak)) + "/s"
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"tr":   tr,
+	"time": func(t time.Time) string { return t.Format("15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+svg { border: 1px solid #ccc; background: #fafafa; }
+polyline { fill: none; stroke: #2a7; stroke-width: 1.5; }
+input { margin-bottom: 0.5em; padding: 4px; width: 30em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Summary}}</p>
+<table>
+{{range .Rows}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+
+<h2>{{tr "Throughput over time"}}</h2>
+{{if .Chart}}<p>{{tr "Peak"}}: {{.Peak}}</p>
+<svg width="800" height="200" viewBox="0 0 800 200"><polyline points="{{.Chart}}"/></svg>
+{{else}}<p>{{tr "The run was too short to chart."}}</p>
+{{end}}
+<h2>{{tr "Errors"}} ({{len .Failures}})</h2>
+{{if .Failures}}<input id="filter" placeholder="{{tr "Filter"}}" oninput="filterErrors(this.value)">
+<table id="errors">
+<tr><th>{{tr "Time"}}</th><th>{{tr "Path"}}</th><th>{{tr "Error"}}</th></tr>
+{{range .Failures}}<tr><td>{{time .Time}}</td><td>{{.Name}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>
+<script>
+function filterErrors(text) {
+  text = text.toLowerCase();
+  var rows = document.getElementById("errors").rows;
+  for (var i = 1; i < rows.length; i++) {
+    rows[i].style.display = rows[i].textContent.toLowerCase().indexOf(text) >= 0 ? "" : "none";
+  }
+}
+</script>
+{{else}}<p>{{tr "No errors."}}</p>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultJobFile is where the plan of a run is kept when job_file is not set
+const defaultJobFile = "gosync-job.json"
+
+var resume = flag.Bool("resume", false, "continue an interrupted run from its job file instead of rescanning")
+
+// errNoJob is returned when there is no interrupted run to resume
+var errNoJob = errors.New("no interrupted job to resume")
+
+// jobPlan is the plan of a run, saved before copying so that an interrupted
+// run can be resumed without rescanning
+type jobPlan struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Started     time.Time `json:"started"`
+	Paths       []string  `json:"paths"`
+	Order       []int32   `json:"order,omitempty"`   // of Paths with sort_order
+	Folders     []bool    `json:"folders,omitempty"` // of Paths, whether a directory
+	Excluded    []string  `json:"excluded"`
+	Files       int64     `json:"files"`
+	Bytes       int64     `json:"bytes"`
+	Growth      int64     `json:"growth"`
+	Created     int64     `json:"created"`
+}
+
+// jobEntry is a line of the log of completed entries
+type jobEntry struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// job records the completed entries of a run next to its plan, in the job
+// file with a ".done" suffix
+type job struct {
+	path string
+	mu   sync.Mutex
+	done *os.File
+}
+
+// startJob saves the plan of a new run
+func startJob(config Config, scan scanResult) (*job, error) {
+	plan := jobPlan{
+		Source:      config.Source,
+		Destination: config.Destination,
+		Started:     time.Now(),
+		Paths:       scan.Paths,
+		Order:       scan.Order,
+		Folders:     scan.Folders,
+		Files:       scan.Files,
+		Bytes:       scan.Bytes,
+		Growth:      scan.Growth,
+		Created:     scan.Created,
+	}
+	for name := range scan.excluded {
+		plan.Excluded = append(plan.Excluded, name)
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(config.JobFile, data); err != nil {
+		return nil, err
+	}
+
+	done, err := os.Create(config.JobFile + ".done")
+	if err != nil {
+		return nil, err
+	}
+	return &job{path: config.JobFile, done: done}, nil
+}
+
+// resumeJob loads the plan of an interrupted run, returning it as a scan
+// result along with the entries already done and the bytes they copied
+func resumeJob(config Config) (*job, scanResult, map[string]bool, int64, error) {
+	var scan scanResult
+	data, err := os.ReadFile(config.JobFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, scan, nil, 0, errNoJob
+	}
+	if err != nil {
+		return nil, scan, nil, 0, err
+	}
+
+	var plan jobPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, scan, nil, 0, fmt.Errorf("invalid job file %s: %v", config.JobFile, err)
+	}
+	if plan.Source != config.Source || plan.Destination != config.Destination {
+		return nil, scan, nil, 0, fmt.Errorf("job file %s is for %s -> %s", config.JobFile, plan.Source, plan.Destination)
+	}
+
+	scan = scanResult{
+		Paths:    plan.Paths,
+		Order:    plan.Order,
+		Folders:  plan.Folders,
+		Files:    plan.Files,
+		Bytes:    plan.Bytes,
+		Growth:   plan.Growth,
+		Created:  plan.Created,
+		excluded: make(map[string]bool, len(plan.Excluded)),
+	}
+	scan.index()
+	for _, name := range plan.Excluded {
+		scan.excluded[name] = true
+	}
+
+	f, err := os.OpenFile(config.JobFile+".done", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, scan, nil, 0, err
+	}
+
+	completed := make(map[string]bool)
+	var doneBytes int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry jobEntry
+		// The last line may have been cut short by the interruption
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			completed[entry.Name] = true
+			doneBytes += entry.Bytes
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, scan, nil, 0, err
+	}
+
+	return &job{path: config.JobFile, done: f}, scan, completed, doneBytes, nil
+}
+
+// markDone records that the entry name is done with, having copied bytes
+func (j *job) markDone(name string, bytes int64) {
+	if j == nil {
+		return
+	}
+	line, _ := json.Marshal(jobEntry{Name: name, Bytes: bytes})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.done.Write(append(line, '\n')); err != nil {
+		errorf("Error recording progress in %s.done: %v\n", j.path, err)
+	}
+}
+
+// keep closes the job of a run that stopped before copying everything, for
+// -resume to copy the rest
+func (j *job) keep() {
+	j.done.Close()
+}
+
+// finish removes the job file once the run is complete
+func (j *job) finish() {
+	j.done.Close()
+	os.Remove(j.path + ".done")
+	os.Remove(j.path)
+}
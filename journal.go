@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the journal
+const (
+	opRun          = "run"
+	opMkdir        = "mkdir"
+	opBackup       = "backup"
+	opCopy         = "copy"
+	opDelete       = "delete"
+	opRemoveDir    = "rmdir"
+	opRemoveSource = "remove_source"
+	opRename       = "rename"
+)
+
+// journalEntry is a line of the journal: an operation about to be carried
+// out, or the outcome of the operation with the same Seq
+type journalEntry struct {
+	Seq     int64     `json:"seq"`
+	Op      string    `json:"op,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	From    string    `json:"from,omitempty"`    // the previous name of a renamed file
+	Created bool      `json:"created,omitempty"` // the destination file did not exist before
+	Backup  string    `json:"backup,omitempty"`  // where the previous version goes
+	Time    time.Time `json:"time"`
+	Done    bool      `json:"done,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// inFlight reports whether the operation was started but never finished
+func (e journalEntry) inFlight() bool {
+	return !e.Done && e.Error == ""
+}
+
+// journal is the append-only record of the operations of the current run
+// on journal_file, written before each operation and again once it is over
+type journal struct {
+	mu    sync.Mutex
+	f     *os.File
+	seq   int64
+	flush bool
+}
+
+// startJournal replaces the journal of the previous run with a new one
+func startJournal(config Config) (*journal, error) {
+	f, err := os.Create(config.JournalFile)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{f: f, flush: config.Fsync}
+	j.write(journalEntry{Op: opRun, Path: config.Destination, Time: time.Now()})
+	return j, nil
+}
+
+func (j *journal) write(entry journalEntry) {
+	line, _ := json.Marshal(entry)
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		errorf("Error writing the journal %s: %v\n", j.f.Name(), err)
+	}
+	if j.flush {
+		j.f.Sync()
+	}
+}
+
+// begin records that the operation of entry is about to be carried out,
+// returning the sequence number to end it with
+func (j *journal) begin(entry journalEntry) int64 {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	entry.Seq, entry.Time = j.seq, time.Now()
+	j.write(entry)
+	return j.seq
+}
+
+// end records the outcome of the operation seq
+func (j *journal) end(seq int64, err error) {
+	if j == nil {
+		return
+	}
+	entry := journalEntry{Seq: seq, Time: time.Now(), Done: err == nil}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.write(entry)
+}
+
+func (j *journal) close() {
+	if j != nil {
+		j.f.Close()
+	}
+}
+
+// readJournal returns the operations of the run recorded in the journal at
+// path, in order and with their outcome, after the entry of the run itself.
+// A last line cut short by a crash is ignored.
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ops []journalEntry
+	index := make(map[int64]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry journalEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if i, ok := index[entry.Seq]; ok && entry.Op == "" {
+			ops[i].Done, ops[i].Error = entry.Done, entry.Error
+			continue
+		}
+		index[entry.Seq] = len(ops)
+		ops = append(ops, entry)
+	}
+	return ops, scanner.Err()
+}
+
+// recoverJournal reports the operations the previous run left in flight.
+// Partial copies of files that did not exist before are removed; the run
+// about to start finishes the other operations, the state DB not having
+// recorded them as done.
+func recoverJournal(path string, dst DestFS) {
+	ops, err := readJournal(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		errorf("Error reading the journal %s: %v\n", path, err)
+		return
+	}
+
+	for _, op := range ops {
+		if op.Op == opRun || !op.inFlight() {
+			continue
+		}
+		destPath := displayPath(dst, op.Path)
+		errorf("Interrupted by the previous run: %s %s\n", op.Op, destPath)
+		if op.Op == opCopy && op.Created {
+			if err := dst.Remove(op.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errorf("Error removing the partial copy %s: %v\n", destPath, err)
+			}
+		}
+	}
+}
+
+// runJournal prints the operations of the last run recorded in the journal
+func runJournal(config Config) error {
+	if config.JournalFile == "" {
+		return fmt.Errorf("journal_file is not set")
+	}
+	ops, err := readJournal(config.JournalFile)
+	if err != nil {
+		return err
+	}
+
+	var done, failed, inFlight int
+	for _, op := range ops {
+		if op.Op == opRun {
+			fmt.Printf(tr("Run of %s to %s\n"), op.Time.Format(time.RFC3339), op.Path)
+			continue
+		}
+		status := tr("done")
+		switch {
+		case op.Error != "":
+			status = tr("failed: ") + op.Error
+			failed++
+		case op.inFlight():
+			status = tr("IN FLIGHT")
+			inFlight++
+		default:
+			done++
+		}
+		fmt.Printf("%s %-13s %s (%s)\n", op.Time.Format(time.RFC3339), op.Op, op.Path, status)
+	}
+	fmt.Printf(tr("%d done, %d failed, %d in flight\n"), done, failed, inFlight)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadJournal(t *testing.T) {
+	const (
+		run    = `{"seq":0,"op":"run","path":"/dst","time":"2026-10-14T10:00:00Z"}` + "\n"
+		copy1  = `{"seq":1,"op":"copy","path":"a","created":true,"time":"2026-10-14T10:00:01Z"}` + "\n"
+		done1  = `{"seq":1,"time":"2026-10-14T10:00:02Z","done":true}` + "\n"
+		del2   = `{"seq":2,"op":"delete","path":"b","time":"2026-10-14T10:00:03Z"}` + "\n"
+		error2 = `{"seq":2,"time":"2026-10-14T10:00:04Z","error":"permission denied"}` + "\n"
+	)
+
+	type op struct {
+		seq      int64
+		op       string
+		inFlight bool
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    []op
+	}{
+		{
+			name:    "complete",
+			content: run + copy1 + done1 + del2 + error2,
+			want:    []op{{0, opRun, true}, {1, opCopy, false}, {2, opDelete, false}},
+		},
+		{
+			name:    "operation in flight",
+			content: run + copy1 + done1 + del2,
+			want:    []op{{0, opRun, true}, {1, opCopy, false}, {2, opDelete, true}},
+		},
+		{
+			name:    "outcome cut short",
+			content: run + copy1 + `{"seq":1,"time":"2026-10-14T10:0`,
+			want:    []op{{0, opRun, true}, {1, opCopy, true}},
+		},
+		{
+			name:    "operation cut short",
+			content: run + copy1 + done1 + `{"seq":2,"op":"del`,
+			want:    []op{{0, opRun, true}, {1, opCopy, false}},
+		},
+		{
+			name:    "empty",
+			content: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal.log")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ops, err := readJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ops) != len(tt.want) {
+				t.Fatalf("read %d operations, want %d: %+v", len(ops), len(tt.want), ops)
+			}
+			for i, got := range ops {
+				if want := tt.want[i]; got.Seq != want.seq || got.Op != want.op || got.inFlight() != want.inFlight {
+					t.Errorf("operation %d = seq %d %s in flight %v, want seq %d %s in flight %v", i, got.Seq, got.Op, got.inFlight(), want.seq, want.op, want.inFlight)
+				}
+			}
+		})
+	}
+
+	if _, err := readJournal(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("readJournal of a missing file: %v, want not exist", err)
+	}
+}
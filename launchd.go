@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// launchdPlist is the launch agent running a sync on a schedule, with its
+// output appended to a log file. KeepAlive restarts only a run that did not
+// finish, as a crash or a logout would leave it.
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%[1]s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%[2]s</string>
+		<string>-quiet</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>%[3]s</string>
+%[4]s	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ProcessType</key>
+	<string>Background</string>
+	<key>LowPriorityIO</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%[5]s</string>
+	<key>StandardErrorPath</key>
+	<string>%[5]s</string>
+</dict>
+</plist>
+`
+
+// runLaunchd is the launchd command: it writes the launch agent running the
+// sync of the config in the current folder every day at a time, or every
+// so many seconds, to stdout or with -install to ~/Library/LaunchAgents,
+// loading it
+func runLaunchd(args []string) error {
+	flags := flag.NewFlagSet("launchd", flag.ExitOnError)
+	label := flags.String("label", "com.github.gosync", "label of the agent")
+	hour := flags.Int("hour", 2, "hour of the daily run")
+	minute := flags.Int("minute", 0, "minute of the daily run")
+	interval := flags.Int("interval", 0, "run every so many seconds instead of daily")
+	logFile := flags.String("log", "", "file the output goes to (default ~/Library/Logs/<label>.log)")
+	install := flags.Bool("install", false, "install the agent and load it")
+	flags.Parse(args)
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if *logFile == "" {
+		*logFile = filepath.Join(home, "Library", "Logs", *label+".log")
+	}
+
+	schedule := fmt.Sprintf("\t<key>StartCalendarInterval</key>\n\t<dict>\n\t\t<key>Hour</key>\n\t\t<integer>%d</integer>\n\t\t<key>Minute</key>\n\t\t<integer>%d</integer>\n\t</dict>\n", *hour, *minute)
+	if *interval > 0 {
+		schedule = fmt.Sprintf("\t<key>StartInterval</key>\n\t<integer>%d</integer>\n", *interval)
+	}
+	plist := fmt.Sprintf(launchdPlist, html.EscapeString(*label), html.EscapeString(exe), html.EscapeString(dir), schedule, html.EscapeString(*logFile))
+	if !*install {
+		fmt.Print(plist)
+		return nil
+	}
+
+	path := filepath.Join(home, "Library", "LaunchAgents", *label+".plist")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(*logFile), 0755); err != nil {
+		return err
+	}
+	// A previous version of the agent must be unloaded for the new one to apply
+	exec.Command("launchctl", "unload", path).Run()
+	if err := os.WriteFile(path, []byte(plist), 0644); err != nil {
+		return err
+	}
+	logf("Wrote %s\n", path)
+	cmd := exec.Command("launchctl", "load", "-w", path)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("launchctl load: %v", err)
+	}
+	logf("Loaded %s, logging to %s\n", *label, *logFile)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// ListingCache keeps the listings of the folders of an rclone destination
+// between runs, with listing_cache_ttl
+type ListingCache struct {
+	Remote string                   `json:"remote"`
+	Dirs   map[string]CachedListing `json:"dirs"` // by folder
+}
+
+// CachedListing is the listing of a folder of the remote
+type CachedListing struct {
+	Time    time.Time     `json:"time"` // when rclone listed it
+	Entries []*rcloneInfo `json:"entries"`
+}
+
+// usesListingCache reports whether config keeps the listings of its
+// destination in the state DB
+func (c Config) usesListingCache() bool {
+	return c.ListingCacheTTL != "" && strings.HasPrefix(c.Destination, rclonePrefix)
+}
+
+// loadListings fills the cache of f with the listings of cache younger
+// than ttl, which are then used as if rclone had just listed them
+func (f *rcloneFS) loadListings(cache *ListingCache, ttl time.Duration) int {
+	if cache == nil || cache.Remote != f.remote {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	loaded := 0
+	for dir, listing := range cache.Dirs {
+		if time.Since(listing.Time) >= ttl {
+			continue
+		}
+		l := &rcloneListing{done: make(chan struct{}), time: listing.Time, entries: make(map[string]*rcloneInfo, len(listing.Entries))}
+		for _, entry := range listing.Entries {
+			l.entries[entry.Base] = entry
+		}
+		close(l.done)
+		f.dirs[dir] = l
+		loaded++
+	}
+	return loaded
+}
+
+// listings returns the listings f holds for the next run, up to date with
+// what this run changed. Those of the folders where a command failed were
+// dropped, and are listed again then.
+func (f *rcloneFS) listings() *ListingCache {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cache := &ListingCache{Remote: f.remote, Dirs: make(map[string]CachedListing, len(f.dirs))}
+	for dir, l := range f.dirs {
+		select {
+		case <-l.done:
+		default:
+			continue // still being listed
+		}
+		if l.err != nil {
+			continue
+		}
+		listing := CachedListing{Time: l.time, Entries: make([]*rcloneInfo, 0, len(l.entries))}
+		for _, entry := range l.entries {
+			listing.Entries = append(listing.Entries, entry)
+		}
+		cache.Dirs[dir] = listing
+	}
+	return cache
+}
+
+// saveListings records the listings of dst in the state DB, once the run
+// changes nothing more at the destination
+func (s *syncer) saveListings() error {
+	remote, ok := baseDest(s.dst).(*rcloneFS)
+	if !ok {
+		return nil
+	}
+	cache := remote.listings()
+	s.state.mu.Lock()
+	s.state.Listings = cache
+	s.state.mu.Unlock()
+	return s.state.save(s.config.StateFile)
+}
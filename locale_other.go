@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// systemLocale is empty: other systems set the locale through LANG and the
+// other variables only
+func systemLocale() string {
+	return ""
+}
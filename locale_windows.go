@@ -0,0 +1,19 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetUserDefaultLocaleName = modkernel32.NewProc("GetUserDefaultLocaleName")
+
+// systemLocale returns the locale name of the user, such as "pt-BR"
+func systemLocale() string {
+	const localeNameMaxLength = 85
+	var name [localeNameMaxLength]uint16
+	n, _, _ := procGetUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&name[0])), localeNameMaxLength)
+	if n == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(name[:])
+}
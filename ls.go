@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// lsEntry is a line of the ls command: a file on disk, its record in the
+// state DB, or both
+type lsEntry struct {
+	name      string
+	info      fs.FileInfo // nil when only the state DB has it
+	file      FileState
+	known     bool // whether the state DB has file
+	tombstone *Tombstone
+	window    time.Duration // of the times of the listed side
+}
+
+// runLs is the ls command: it lists a folder of the source, or of the
+// destination with -dest, next to what the state DB recorded for each file,
+// to find out why a file keeps being copied
+func runLs(config Config, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	dest := flags.Bool("dest", false, "list the destination instead of the source")
+	flags.Parse(args)
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = path.Clean(strings.ReplaceAll(flags.Arg(0), `\`, "/"))
+	}
+	if !fs.ValidPath(dir) {
+		return fmt.Errorf("invalid path %q: must be relative to the source", flags.Arg(0))
+	}
+
+	var fsys fs.FS = newOSFS(config.Source)
+	var window time.Duration
+	if *dest {
+		dst := withTargetNames(openDestination(config), config)
+		fsys, window = dst, modifyWindow(dst)
+	}
+	state := &State{}
+	if config.StateFile != "" {
+		var err error
+		if state, err = loadState(config.StateFile); err != nil {
+			return fmt.Errorf("cannot read state file: %v", err)
+		}
+	}
+
+	entries := make(map[string]*lsEntry)
+	entry := func(name string) *lsEntry {
+		if entries[name] == nil {
+			entries[name] = &lsEntry{name: name, window: window}
+		}
+		return entries[name]
+	}
+
+	// A single file, or the contents of a folder
+	info, err := fs.Stat(fsys, dir)
+	switch {
+	case err == nil && !info.IsDir():
+		entry(dir).info = info
+	case err == nil:
+		children, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			info, err := child.Info()
+			if err != nil {
+				return err
+			}
+			entry(path.Join(dir, child.Name())).info = info
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	// The files of the state DB in there, even gone from the disk
+	inDir := func(name string) bool { return name == dir || path.Dir(name) == dir }
+	for name, file := range state.Files {
+		if inDir(name) {
+			e := entry(name)
+			e.file, e.known = file, true
+		}
+	}
+	for name, tombstone := range state.Tombstones {
+		if inDir(name) {
+			tombstone := tombstone
+			entry(name).tombstone = &tombstone
+		}
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s: %w", dir, fs.ErrNotExist)
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, tr("NAME\tSIZE\tMODIFIED\tSTATE\tSYNCED\tHASH"))
+	for _, name := range names {
+		e := entries[name]
+		size, modified := "-", "-"
+		if e.info != nil {
+			size, modified = fmt.Sprint(e.info.Size()), e.info.ModTime().Format(time.RFC3339)
+			if e.info.IsDir() {
+				size, name = "-", name+"/"
+			}
+		}
+		synced, hash := "-", "-"
+		if e.known {
+			synced = e.file.Synced.Format(time.RFC3339)
+			if e.file.Hash != "" {
+				hash = e.file.Hash
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, size, modified, e.status(), synced, hash)
+	}
+	return w.Flush()
+}
+
+// status describes the file against its record in the state DB
+func (e *lsEntry) status() string {
+	switch {
+	case e.info != nil && e.info.IsDir():
+		return tr("directory")
+	case e.tombstone != nil && e.tombstone.Propagated:
+		return tr("deleted")
+	case e.tombstone != nil:
+		return tr("deletion pending")
+	case !e.known:
+		return tr("not synced")
+	case e.info == nil:
+		return tr("missing")
+	case !e.file.matches(e.info, e.window):
+		// The next sync compares it with the destination again
+		return fmt.Sprintf(tr("changed (synced %d bytes, %s)"), e.file.Size, e.file.ModTime.Format(time.RFC3339))
+	}
+	return tr("synced")
+}
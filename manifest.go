@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// signatureSuffix names the signature of a manifest, next to it
+const signatureSuffix = ".sig"
+
+// errManifestMismatch is returned by verify-manifest when the signature or
+// the files do not match
+var errManifestMismatch = errors.New("the destination does not match the manifest")
+
+// writeManifest hashes every file of the destination into manifest_file,
+// in the format of sha256sum, and signs it with manifest_key when set, as
+// tamper evidence for archives. Each version kept by append_only is a file
+// of its own.
+func (s *syncer) writeManifest() error {
+	manifest, _ := filepath.Abs(s.config.ManifestFile)
+	dst := s.stored
+	var lines []string
+	err := fs.WalkDir(dst, ".", func(name string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir() && s.inBackupDir(name):
+			return fs.SkipDir
+		case d.IsDir(), d.Name() == metadataSidecar && s.config.MetadataSidecar:
+			return nil
+		}
+		if path, _ := filepath.Abs(displayPath(dst, name)); path == manifest || path == manifest+signatureSuffix {
+			return nil
+		}
+		sum, err := hashFile(dst, name, hashSHA256)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, hex.EncodeToString(sum)+"  "+name+"\n")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(lines)
+	data := []byte(strings.Join(lines, ""))
+	if err := writeFileAtomic(s.config.ManifestFile, data); err != nil {
+		return err
+	}
+	logf("Wrote the manifest of %d files to %s\n", len(lines), s.config.ManifestFile)
+
+	if s.config.ManifestKey == "" {
+		return nil
+	}
+	key, err := readSigningKey(s.config.ManifestKey)
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n"
+	return writeFileAtomic(s.config.ManifestFile+signatureSuffix, []byte(signature))
+}
+
+// readSigningKey reads an Ed25519 private key in PKCS #8 PEM, as written by
+// openssl genpkey -algorithm ed25519
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return private, nil
+}
+
+// readVerifyingKey reads an Ed25519 public key in PKIX PEM, as written by
+// openssl pkey -pubout
+func readVerifyingKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	return block, nil
+}
+
+// runVerifyManifest is the verify-manifest command: it checks the signature
+// of the manifest with the public key given by -key, then rehashes the
+// files of the destination it lists
+func runVerifyManifest(config Config, args []string) error {
+	flags := flag.NewFlagSet("verify-manifest", flag.ExitOnError)
+	keyPath := flags.String("key", "", "Ed25519 public key (PEM) to check the signature with")
+	flags.Parse(args)
+	manifest := config.ManifestFile
+	if flags.NArg() > 0 {
+		manifest = flags.Arg(0)
+	}
+	if manifest == "" {
+		return fmt.Errorf("manifest_file is not set")
+	}
+
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	mismatches := 0
+	if *keyPath != "" {
+		key, err := readVerifyingKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		encoded, err := os.ReadFile(manifest + signatureSuffix)
+		if err != nil {
+			return fmt.Errorf("cannot read the signature: %v", err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil || !ed25519.Verify(key, data, signature) {
+			errorf("The signature of %s does not match its content\n", manifest)
+			mismatches++
+		} else {
+			logf("The signature of %s is valid\n", manifest)
+		}
+	}
+
+	dst := storedDest(config)
+	files := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		recorded, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		files++
+		sum, err := hashFile(dst, name, hashSHA256)
+		switch {
+		case err != nil:
+			errorf("%s: %v\n", displayPath(dst, name), err)
+			mismatches++
+		case hex.EncodeToString(sum) != recorded:
+			errorf("%s: content changed since the manifest was written\n", displayPath(dst, name))
+			mismatches++
+		default:
+			debugf("%s: OK\n", displayPath(dst, name))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	logf("Checked %d files against %s, %d mismatches\n", files, manifest, mismatches)
+	if mismatches > 0 {
+		return errManifestMismatch
+	}
+	return nil
+}
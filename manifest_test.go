@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestManifestWithVersions(t *testing.T) {
+	mtime := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	src := fstest.MapFS{
+		"x.txt":   {Data: []byte("first"), ModTime: mtime},
+		"a/y.txt": {Data: []byte("other"), ModTime: mtime},
+	}
+	dir := t.TempDir()
+	config := testConfig(t, map[string]any{
+		"append_only":   true,
+		"state_file":    filepath.Join(dir, "state.json"),
+		"manifest_file": filepath.Join(dir, "manifest.sha256"),
+		"audit_percent": 100,
+	})
+
+	if _, err := SyncFS(src, config); err != nil {
+		t.Fatal(err)
+	}
+	src["x.txt"] = &fstest.MapFile{Data: []byte("second"), ModTime: mtime.Add(time.Hour)}
+	if _, err := SyncFS(src, config); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(config.ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("the manifest lists %d files, want x.txt, its version and a/y.txt:\n%s", len(lines), data)
+	}
+	sums := make(map[string]bool)
+	for _, line := range lines {
+		sum, _, _ := strings.Cut(line, "  ")
+		sums[sum] = true
+	}
+	if len(sums) != 3 {
+		t.Errorf("the versions of x.txt have the same hash in the manifest:\n%s", data)
+	}
+
+	if err := runVerifyManifest(config, nil); err != nil {
+		t.Errorf("verify-manifest: %v", err)
+	}
+	if err := runAudit(config); err != nil {
+		t.Errorf("audit: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "runtime/debug"
+
+// applyMemoryLimit sets memory_limit as the soft limit of the Go runtime,
+// which collects garbage more often as the heap nears it. It is not a
+// ceiling: the plan holds every path of the source, so a tree that needs
+// more than the limit still grows past it, only with more collections.
+func applyMemoryLimit(config Config) {
+	if config.MemoryLimit == "" {
+		return
+	}
+	limit, _ := parseSize(config.MemoryLimit) // checked by ReadConfig
+	debug.SetMemoryLimit(limit)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Languages of the messages, selected by the language option
+const (
+	languageEnglish    = "en"
+	languagePortuguese = "pt-BR"
+)
+
+// messages is the catalog of the current language, keyed by the English
+// format, or nil for English
+var messages = catalogs[detectLanguage()]
+
+var catalogs = map[string]map[string]string{
+	languagePortuguese: messagesPortuguese,
+}
+
+// setLanguage switches the messages to language, or back to the language
+// of the system when empty
+func setLanguage(language string) {
+	if language == "" {
+		language = detectLanguage()
+	}
+	messages = catalogs[language]
+}
+
+// tr translates a message format, keeping the English one when it has no
+// translation
+func tr(format string) string {
+	if translated, ok := messages[format]; ok {
+		return translated
+	}
+	return format
+}
+
+// detectLanguage picks the language from the locale variables, in their
+// POSIX order of precedence, or else from the system settings
+func detectLanguage() string {
+	locale := ""
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale = os.Getenv(name); locale != "" {
+			break
+		}
+	}
+	if locale == "" {
+		locale = systemLocale()
+	}
+	if strings.HasPrefix(strings.ToLower(locale), "pt") {
+		return languagePortuguese
+	}
+	return languageEnglish
+}
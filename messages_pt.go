@@ -0,0 +1,259 @@
+package main
+
+// messagesPortuguese translates the messages to Brazilian Portuguese. The
+// keys must match the English formats exactly, verbs included.
+var messagesPortuguese = map[string]string{
+	// Sync
+	"%d files (%s) to copy\n":                                     "%d arquivos (%s) a copiar\n",
+	"%d files (%s) to copy, %d to delete\n":                       "%d arquivos (%s) a copiar, %d a apagar\n",
+	"No interrupted job to resume, starting a new one\n":          "Nenhuma execução interrompida para continuar, iniciando uma nova\n",
+	"Resuming the job: %d of %d entries already done\n":           "Continuando a execução: %d de %d itens já concluídos\n",
+	"Worker %d: Copying %s to %s\n":                               "Worker %d: Copiando %s para %s\n",
+	"Worker %d: Skipping %s: %s\n":                                "Worker %d: Ignorando %s: %s\n",
+	"Worker %d: Not copying %s: %s\n":                             "Worker %d: Não copiando %s: %s\n",
+	"Worker %d: Error comparing files %s and %s: %v\n":            "Worker %d: Erro ao comparar os arquivos %s e %s: %v\n",
+	"Worker %d: Error copying file %s to %s: %v\n":                "Worker %d: Erro ao copiar o arquivo %s para %s: %v\n",
+	"Worker %d: Error copying alternate data streams of %s: %v\n": "Worker %d: Erro ao copiar os fluxos alternativos de dados de %s: %v\n",
+	"Worker %d: Error setting permissions for %s: %v\n":           "Worker %d: Erro ao definir as permissões de %s: %v\n",
+	"Worker %d: Error setting times for %s: %v\n":                 "Worker %d: Erro ao definir as datas de %s: %v\n",
+	"Worker %d: Error logging file %s: %v\n":                      "Worker %d: Erro ao registrar o arquivo %s: %v\n",
+	"Worker %d: Error running on_copy for %s: %v\n":               "Worker %d: Erro ao executar o on_copy para %s: %v\n",
+	"Worker %d: Error reading %s: %v\n":                           "Worker %d: Erro ao ler %s: %v\n",
+	"Worker %d: Error removing %s: %v\n":                          "Worker %d: Erro ao remover %s: %v\n",
+	"Worker %d: Not removing %s, verification failed: %v\n":       "Worker %d: %s não foi removido, a conferência falhou: %v\n",
+	"Worker %d: Removed %s\n":                                     "Worker %d: %s removido\n",
+	"Error creating directory %s: %v\n":                           "Erro ao criar a pasta %s: %v\n",
+	"Error setting permissions for %s: %v\n":                      "Erro ao definir as permissões de %s: %v\n",
+	"Error flushing directory %s: %v\n":                           "Erro ao gravar no disco a pasta %s: %v\n",
+	"Error reading %s: %v\n":                                      "Erro ao ler %s: %v\n",
+	"Error syncing directories: %v\n":                             "Erro ao sincronizar as pastas: %v\n",
+	"Error reading config: %v\n":                                  "Erro ao ler a configuração: %v\n",
+	"Invalid progress format %q\n":                                "Formato de progresso inválido %q\n",
+	"Unknown command %q\n":                                        "Comando desconhecido %q\n",
+	"Warning: %v\n":                                               "Aviso: %v\n",
+	"Warning: unusual run: %v\n":                                  "Aviso: execução fora do comum: %v\n",
+	"Direct I/O unavailable for %s: %v\n":                         "E/S direta indisponível para %s: %v\n",
+	"Cannot lower the priority of the process: %v\n":              "Não foi possível reduzir a prioridade do processo: %v\n",
+	"Cannot check free space on %s: %v\n":                         "Não foi possível verificar o espaço livre em %s: %v\n",
+	"Cannot check free inodes on %s: %v\n":                        "Não foi possível verificar os inodes livres em %s: %v\n",
+	"Bandwidth limit: %s/s\n":                                     "Limite de banda: %s/s\n",
+	"Bandwidth limit: off\n":                                      "Limite de banda: desligado\n",
+	"Error recording progress in %s.done: %v\n":                   "Erro ao registrar o progresso em %s.done: %v\n",
+	"%d errors in a row, pausing all workers for %s\n":            "%d erros seguidos, pausando todos os workers por %s\n",
+	"Destination still failing (%v), retrying in %s\n":            "O destino continua falhando (%v), tentando de novo em %s\n",
+	"Destination responding again, resuming\n":                    "O destino voltou a responder, continuando\n",
+	"%d destination paths are too long and will not be copied:\n": "%d caminhos no destino são longos demais e não serão copiados:\n",
+	"Storing %s as %s: %s on %s\n":                                "Gravando %s como %s: %s em %s\n",
+	"%s reappeared at the source after being deleted on %s\n":     "%s reapareceu na origem depois de ser apagado em %s\n",
+	"Error writing metadata to %s: %v\n":                          "Erro ao gravar os metadados em %s: %v\n",
+	"Error listing backups in %s: %v\n":                           "Erro ao listar os backups em %s: %v\n",
+	"Removed old backup %s\n":                                     "Backup antigo %s removido\n",
+	"Error removing old backup %s: %v\n":                          "Erro ao remover o backup antigo %s: %v\n",
+
+	// Deletions
+	"Deleted %s\n":                               "%s apagado\n",
+	"Deleted excluded %s\n":                      "%s excluído pelos filtros apagado\n",
+	"Error deleting %s: %v\n":                    "Erro ao apagar %s: %v\n",
+	"Error deleting excluded %s: %v\n":           "Erro ao apagar %s, excluído pelos filtros: %v\n",
+	"Error checking %s before deleting it: %v\n": "Erro ao verificar %s antes de apagá-lo: %v\n",
+	"Not deleting %s: it changed at the destination since it was synced\n": "%s não foi apagado: mudou no destino desde a sincronização\n",
+	"Error listing %s for delete_excluded: %v\n":                           "Erro ao listar %s para o delete_excluded: %v\n",
+	"Error listing directories of %s: %v\n":                                "Erro ao listar as pastas de %s: %v\n",
+	"Removed empty directory %s\n":                                         "Pasta vazia %s removida\n",
+	"Error removing empty directory %s: %v\n":                              "Erro ao remover a pasta vazia %s: %v\n",
+	"Removed excluded directory %s\n":                                      "Pasta %s, excluída pelos filtros, removida\n",
+	"Error removing excluded directory %s: %v\n":                           "Erro ao remover a pasta %s, excluída pelos filtros: %v\n",
+
+	// Commands
+	"Error running benchmark: %v\n":                        "Erro ao executar o benchmark: %v\n",
+	"Error comparing the source and the destination: %v\n": "Erro ao comparar a origem e o destino: %v\n",
+	"Error exporting the state: %v\n":                      "Erro ao exportar o estado: %v\n",
+	"Error importing the state: %v\n":                      "Erro ao importar o estado: %v\n",
+	"Exported %d files to %s\n":                            "%d arquivos exportados para %s\n",
+	"Imported %d files into %s\n":                          "%d arquivos importados para %s\n",
+	"Error reading the journal: %v\n":                      "Erro ao ler o journal: %v\n",
+	"Error reading the journal %s: %v\n":                   "Erro ao ler o journal %s: %v\n",
+	"Error writing the journal %s: %v\n":                   "Erro ao gravar o journal %s: %v\n",
+	"Error writing the report %s: %v\n":                    "Erro ao gravar o relatório %s: %v\n",
+	"Interrupted by the previous run: %s %s\n":             "Interrompido pela execução anterior: %s %s\n",
+	"Error removing the partial copy %s: %v\n":             "Erro ao remover a cópia parcial %s: %v\n",
+	"Error listing: %v\n":                                  "Erro ao listar: %v\n",
+	"Error rolling back: %v\n":                             "Erro ao desfazer a execução: %v\n",
+	"Undid %s %s\n":                                        "Desfeito: %s %s\n",
+	"Cannot undo %s %s: %v\n":                              "Não foi possível desfazer %s %s: %v\n",
+	"Not undoing the removal of %s from the source\n":      "A remoção de %s da origem não é desfeita\n",
+	"Rolled back %d operations, %d could not be undone\n":  "%d operações desfeitas, %d não puderam ser desfeitas\n",
+	"Error restoring metadata: %v\n":                       "Erro ao restaurar os metadados: %v\n",
+	"Cannot read %s: %v\n":                                 "Não foi possível ler %s: %v\n",
+	"Cannot restore metadata of %s: %v\n":                  "Não foi possível restaurar os metadados de %s: %v\n",
+	"Not restoring metadata of %s: not found\n":            "Metadados de %s não restaurados: arquivo não encontrado\n",
+	"Restored the metadata of %d files, %d failed\n":       "Metadados de %d arquivos restaurados, %d falharam\n",
+	"Error auditing the destination: %v\n":                 "Erro ao auditar o destino: %v\n",
+	"Corrupt %s: %s hash %x, %x when synced\n":             "%s corrompido: hash %s %x, %x na sincronização\n",
+	"Missing %s\n":                                         "%s não encontrado\n",
+	"Skipping %s: changed since it was synced\n":           "Ignorando %s: mudou desde a sincronização\n",
+	"Verified %s\n":                                        "%s conferido\n",
+	"Run of %s to %s\n":                                    "Execução de %s para %s\n",
+	"%d done, %d failed, %d in flight\n":                   "%d concluídas, %d com falha, %d em andamento\n",
+	"done":                                                 "concluída",
+	"failed: ":                                             "falhou: ",
+	"IN FLIGHT":                                            "EM ANDAMENTO",
+	"Audited %d of %d files (%s) in %s: %d corrupt, %d changed, %d missing, %d given a reference hash, %d errors\n": "%d de %d arquivos (%s) auditados em %s: %d corrompidos, %d alterados, %d não encontrados, %d com hash de referência registrado, %d erros\n",
+
+	// destination_format chunks
+	"Skipping %s: %s\n":                           "Ignorando %s: %s\n",
+	"unchanged since the last snapshot":           "sem alterações desde o último snapshot",
+	"Worker %d: Stored %s: %d chunks, %s new\n":   "Worker %d: %s armazenado: %d blocos, %s novos\n",
+	"Worker %d: Error storing %s: %v\n":           "Worker %d: Erro ao armazenar %s: %v\n",
+	"Snapshot %s: %d entries, %s of new chunks\n": "Snapshot %s: %d entradas, %s de blocos novos\n",
+
+	"SNAPSHOT\tFILES\tSIZE\tSOURCE":                         "SNAPSHOT\tARQUIVOS\tTAMANHO\tORIGEM",
+	"Error listing the snapshots: %v\n":                     "Erro ao listar os snapshots: %v\n",
+	"Error restoring the snapshot: %v\n":                    "Erro ao restaurar o snapshot: %v\n",
+	"Error restoring %s: %v\n":                              "Erro ao restaurar %s: %v\n",
+	"Restored %s\n":                                         "%s restaurado\n",
+	"Restored %d entries of snapshot %s to %s, %d errors\n": "%d entradas do snapshot %s restauradas em %s, %d erros\n",
+
+	// manifest_file
+	"Wrote the manifest of %d files to %s\n":               "Manifesto de %d arquivos gravado em %s\n",
+	"Error writing the manifest %s: %v\n":                  "Erro ao gravar o manifesto %s: %v\n",
+	"Error verifying the manifest: %v\n":                   "Erro ao verificar o manifesto: %v\n",
+	"The signature of %s does not match its content\n":     "A assinatura de %s não corresponde ao conteúdo\n",
+	"The signature of %s is valid\n":                       "A assinatura de %s é válida\n",
+	"%s: content changed since the manifest was written\n": "%s: o conteúdo mudou desde a gravação do manifesto\n",
+	"Checked %d files against %s, %d mismatches\n":         "%d arquivos conferidos com %s, %d divergências\n",
+
+	// gc
+	"Would remove %s\n": "Removeria %s\n",
+	"Removing %s\n":     "Removendo %s\n",
+	"%d expired snapshots, %d unreferenced chunks\n": "%d snapshots expirados, %d blocos sem referência\n",
+	"%d expired backups\n":                           "%d backups expirados\n",
+	"Would reclaim %s by removing %d entries\n":      "Liberaria %s removendo %d entradas\n",
+	"Reclaimed %s by removing %d entries\n":          "%s liberados removendo %d entradas\n",
+
+	// max_transfer, max_files, max_duration
+	"max_files %d reached": "max_files %d atingido",
+	"over max_transfer %s": "acima do max_transfer %s",
+	"Transfer caps reached: %d files (%s) left for the next run\n":              "Limites de transferência atingidos: %d arquivos (%s) ficam para a próxima execução\n",
+	"max_duration %s reached":                                                   "max_duration %s atingido",
+	"Stopped after max_duration %s: %d entries not checked, left for -resume\n": "Parado após o max_duration %s: %d entradas não verificadas, que ficam para o -resume\n",
+
+	// confirm
+	"Proceed? [y/N] ": "Continuar? [s/N] ",
+	"y":               "s",
+	"yes":             "sim",
+
+	// systemd
+	"Wrote %s\n":                  "Gravado %s\n",
+	"Enabled %s.timer: %s\n":      "%s.timer ativado: %s\n",
+	"Scanning the source":         "Analisando a origem",
+	"Copying %d files (%s)":       "Copiando %d arquivos (%s)",
+	"Cannot notify systemd: %v\n": "Não foi possível notificar o systemd: %v\n",
+
+	// launchd
+	"Loaded %s, logging to %s\n": "%s carregado, com o log em %s\n",
+
+	// debug-addr
+	"Serving diagnostics on http://%s/debug/gosync and /debug/pprof/\n": "Diagnóstico disponível em http://%s/debug/gosync e /debug/pprof/\n",
+	"Error serving diagnostics: %v\n":                                   "Erro ao servir o diagnóstico: %v\n",
+
+	// trace_endpoint
+	"Error exporting traces: %v\n":                                       "Erro ao exportar os traces: %v\n",
+	"Error exporting traces: %s answered %s\n":                           "Erro ao exportar os traces: %s respondeu %s\n",
+	"Warning: the trace collector was too slow, %d spans were dropped\n": "Aviso: o coletor de traces foi lento demais, %d spans foram descartados\n",
+
+	// special_files
+	"named pipe":                            "pipe nomeado",
+	"socket":                                "socket",
+	"device":                                "dispositivo",
+	"%s, not a regular file":                "%s, não é um arquivo comum",
+	"socket, which cannot be recreated":     "socket, que não pode ser recriado",
+	"already at the destination":            "já existe no destino",
+	"Worker %d: Warning: skipping %s: %s\n": "Worker %d: Aviso: ignorando %s: %s\n",
+	"Worker %d: Error copying %s: %s\n":     "Worker %d: Erro ao copiar %s: %s\n",
+	"Worker %d: Error recreating %s: %v\n":  "Worker %d: Erro ao recriar %s: %v\n",
+	"Worker %d: Recreated %s %s\n":          "Worker %d: Recriado %s %s\n",
+
+	// listing_cache_ttl
+	"Using %d cached listings of the destination\n": "Usando %d listagens do destino guardadas em cache\n",
+	"Error collecting garbage: %v\n":                "Erro na coleta de lixo: %v\n",
+
+	// detect_renames
+	"Renamed %s to %s instead of copying it\n": "%s renomeado para %s em vez de copiado\n",
+	"Error renaming %s to %s: %v\n":            "Erro ao renomear %s para %s: %v\n",
+	"Error setting times for %s: %v\n":         "Erro ao definir as datas de %s: %v\n",
+	"from %s":                                  "de %s",
+
+	// quarantine
+	"quarantined after failing %d runs in a row":                                                  "em quarentena após falhar %d execuções seguidas",
+	"%d files are quarantined and were not copied; clear them with sync.exe quarantine -clear:\n": "%d arquivos estão em quarentena e não foram copiados; libere-os com sync.exe quarantine -clear:\n",
+	"%d files taken out of quarantine\n":                                                          "%d arquivos retirados da quarentena\n",
+	"NAME\tRUNS\tQUARANTINED\tLAST ERROR":                                                         "NOME\tEXECUÇÕES\tQUARENTENA\tÚLTIMO ERRO",
+	"Error reading the quarantine: %v\n":                                                          "Erro ao ler a quarentena: %v\n",
+
+	// du
+	"SIZE\tFILES\tDIRECTORY":          "TAMANHO\tARQUIVOS\tPASTA",
+	"(files at the root)":             "(arquivos na raiz)",
+	"total":                           "total",
+	"Error scanning the source: %v\n": "Erro ao analisar a origem: %v\n",
+
+	// ls
+	"NAME\tSIZE\tMODIFIED\tSTATE\tSYNCED\tHASH": "NOME\tTAMANHO\tMODIFICADO\tSITUAÇÃO\tSINCRONIZADO\tHASH",
+	"directory":                     "pasta",
+	"deleted":                       "apagado",
+	"deletion pending":              "exclusão pendente",
+	"not synced":                    "não sincronizado",
+	"missing":                       "ausente",
+	"synced":                        "sincronizado",
+	"changed (synced %d bytes, %s)": "alterado (sincronizado com %d bytes, %s)",
+
+	// Summary
+	"Copied %d files (%s), skipped %d, %d errors in %s": "%d arquivos copiados (%s), %d ignorados, %d erros em %s",
+	", %d deleted":                 ", %d apagados",
+	", %d renamed":                 ", %d renomeados",
+	", %d removed from the source": ", %d removidos da origem",
+	"File sizes":                   "Tamanhos dos arquivos",
+	"Throughput per file":          "Velocidade por arquivo",
+	"Largest files:\n":             "Maiores arquivos:\n",
+	"Slowest files:\n":             "Arquivos mais lentos:\n",
+	"  %-26s %8d files %12s  %s":   "  %-26s %8d arquivos %12s  %s",
+
+	// HTML report
+	"GoSync run of %s":                "Execução do GoSync de %s",
+	"Files copied":                    "Arquivos copiados",
+	"Bytes copied":                    "Bytes copiados",
+	"Files skipped":                   "Arquivos ignorados",
+	"Files deleted":                   "Arquivos apagados",
+	"Errors":                          "Erros",
+	"Duration":                        "Duração",
+	"Average throughput":              "Velocidade média",
+	"Throughput over time":            "Velocidade ao longo do tempo",
+	"Peak":                            "Pico",
+	"The run was too short to chart.": "A execução foi curta demais para o gráfico.",
+	"Filter":                          "Filtrar",
+	"Time":                            "Hora",
+	"Path":                            "Caminho",
+	"Error":                           "Erro",
+	"No errors.":                      "Nenhum erro.",
+
+	// Reasons for leaving a file out
+	"same size and modification time at the destination":           "mesmo tamanho e data de modificação no destino",
+	"already exists at the destination":                            "já existe no destino",
+	"not newer than the destination":                               "não é mais recente que o destino",
+	"same size and checksum at the destination":                    "mesmo tamanho e checksum no destino",
+	"excluded by exclude_cmd":                                      "excluído pelo exclude_cmd",
+	"metadata sidecar":                                             "arquivo de metadados",
+	"excluded":                                                     "excluído pelos filtros",
+	"changed type since the scan":                                  "mudou de tipo desde a varredura",
+	"matches %q in %s":                                             "casa com %q em %s",
+	"extension %s is in skip_extensions":                           "a extensão %s está em skip_extensions",
+	"owned by user %d, which is in skip_owners":                    "pertence ao usuário %d, que está em skip_owners",
+	"owned by group %d, which is in skip_groups":                   "pertence ao grupo %d, que está em skip_groups",
+	"%q contains %q":                                               "%q contém %q",
+	"%q is a reserved name":                                        "%q é um nome reservado",
+	"%q ends with a dot or a space":                                "%q termina com ponto ou espaço",
+	"%s on %s":                                                     "%s em %s",
+	"it would overwrite %s on %s":                                  "sobrescreveria %s em %s",
+	"the name %.20q... is %d characters long, over %d":             "o nome %.20q... tem %d caracteres, acima de %d",
+	"the destination path is %d characters long, over max_path %d": "o caminho no destino tem %d caracteres, acima do max_path %d",
+}
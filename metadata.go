@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metadataSidecar is the file, in each destination directory, that keeps
+// the metadata of its entries with metadata_sidecar
+const metadataSidecar = ".gosync-meta.json"
+
+// fileMetadata is what a destination that cannot store it, such as FAT32,
+// loses of a source entry
+type fileMetadata struct {
+	Mode       string    `json:"mode"`
+	UID        *uint32   `json:"uid,omitempty"`
+	GID        *uint32   `json:"gid,omitempty"`
+	ModTime    time.Time `json:"mtime"`
+	AccessTime time.Time `json:"atime"`
+}
+
+// metadataIndex gathers the metadata recorded during a run, by directory,
+// until it is written to the sidecars at the end. A nil entry is one to
+// drop from its sidecar.
+type metadataIndex struct {
+	mu   sync.Mutex
+	dirs map[string]map[string]*fileMetadata
+}
+
+func newMetadataIndex() *metadataIndex {
+	return &metadataIndex{dirs: make(map[string]map[string]*fileMetadata)}
+}
+
+// record keeps the metadata of the source entry name, described by info
+func (m *metadataIndex) record(name string, info fs.FileInfo) {
+	if m == nil || name == "." {
+		return
+	}
+	meta := &fileMetadata{
+		Mode:       fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime:    info.ModTime(),
+		AccessTime: accessTime(info),
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		meta.UID, meta.GID = &uid, &gid
+	}
+	m.set(name, meta)
+}
+
+// forget drops name, deleted from the destination, from its sidecar
+func (m *metadataIndex) forget(name string) {
+	if m == nil {
+		return
+	}
+	m.set(name, nil)
+}
+
+func (m *metadataIndex) set(name string, meta *fileMetadata) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir := path.Dir(name)
+	if m.dirs[dir] == nil {
+		m.dirs[dir] = make(map[string]*fileMetadata)
+	}
+	m.dirs[dir][path.Base(name)] = meta
+}
+
+// flush merges what was recorded into the sidecars of dst, removing those
+// left with no entries
+func (m *metadataIndex) flush(dst DestFS) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed int
+	for dir, changes := range m.dirs {
+		name := path.Join(dir, metadataSidecar)
+		if err := updateSidecar(dst, name, changes); err != nil {
+			errorf("Error writing metadata to %s: %v\n", displayPath(dst, name), err)
+			failed++
+		}
+	}
+	clear(m.dirs)
+	if failed > 0 {
+		return fmt.Errorf("%d metadata files could not be written", failed)
+	}
+	return nil
+}
+
+func updateSidecar(dst DestFS, name string, changes map[string]*fileMetadata) error {
+	entries, err := readSidecar(dst, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if entries == nil {
+		entries = make(map[string]fileMetadata)
+	}
+	for base, meta := range changes {
+		base = destName(dst, base)
+		if meta == nil {
+			delete(entries, base)
+		} else {
+			entries[base] = *meta
+		}
+	}
+
+	if len(entries) == 0 {
+		if err := dst.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if local, ok := dst.(localFS); ok {
+		return writeFileAtomic(local.localPath(name), data)
+	}
+	w, err := dst.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func readSidecar(fsys fs.FS, name string) (map[string]fileMetadata, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]fileMetadata
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return entries, nil
+}
+
+// runRestoreMetadata is the restore-metadata command: it reapplies the
+// permissions, owners and times kept in the sidecars to the files of a
+// folder restored from the destination, the destination itself by default
+func runRestoreMetadata(config Config, args []string) error {
+	root := config.Destination
+	if len(args) > 0 {
+		root = args[0]
+	}
+
+	var sidecars []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == metadataSidecar {
+			sidecars = append(sidecars, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Deepest first, so that restoring the contents of a directory is done
+	// by the time its own times are set
+	sort.Sort(sort.Reverse(sort.StringSlice(sidecars)))
+
+	var restored, failed int
+	for _, sidecar := range sidecars {
+		data, err := os.ReadFile(sidecar)
+		var entries map[string]fileMetadata
+		if err == nil {
+			err = json.Unmarshal(data, &entries)
+		}
+		if err != nil {
+			errorf("Cannot read %s: %v\n", sidecar, err)
+			failed++
+			continue
+		}
+		for base, meta := range entries {
+			path := filepath.Join(filepath.Dir(sidecar), base)
+			if err := applyMetadata(path, meta); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					debugf("Not restoring metadata of %s: not found\n", path)
+					continue
+				}
+				errorf("Cannot restore metadata of %s: %v\n", path, err)
+				failed++
+				continue
+			}
+			restored++
+		}
+	}
+
+	logf("Restored the metadata of %d files, %d failed\n", restored, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be restored", failed)
+	}
+	return nil
+}
+
+func applyMetadata(path string, meta fileMetadata) error {
+	var mode uint32
+	if _, err := fmt.Sscanf(meta.Mode, "%o", &mode); err != nil {
+		return fmt.Errorf("invalid mode %q", meta.Mode)
+	}
+	if err := os.Chmod(path, fs.FileMode(mode)); err != nil {
+		return err
+	}
+	if ownersSupported && meta.UID != nil && meta.GID != nil {
+		if err := os.Lchown(path, int(*meta.UID), int(*meta.GID)); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(path, meta.AccessTime, meta.ModTime)
+}
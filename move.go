@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"path"
+)
+
+var move = flag.Bool("move", false, "delete each source file once its copy is verified")
+
+// removeFS is implemented by sources that files can be deleted from
+type removeFS interface {
+	Remove(name string) error
+}
+
+// errSourceReadOnly is returned when moving files out of a read-only source
+var errSourceReadOnly = errors.New("the source does not support deleting files, which move requires")
+
+// moveSource deletes the file name from the source once its copy at the
+// destination is verified
+func (s *syncer) moveSource(id int, name string) {
+	path := displayPath(s.src, name)
+	if err := verifyCopy(s.src, s.dst, name, s.config.Hash); err != nil {
+		errorf("Worker %d: Not removing %s, verification failed: %v\n", id, path, err)
+		s.stats.addError()
+		return
+	}
+
+	seq := s.journal.begin(journalEntry{Op: opRemoveSource, Path: name})
+	err := s.src.(removeFS).Remove(name)
+	s.journal.end(seq, err)
+	if err != nil {
+		errorf("Worker %d: Error removing %s: %v\n", id, path, err)
+		s.stats.addError()
+		return
+	}
+	// Gone from the source on purpose, so not a deletion to propagate
+	s.state.forget(name)
+	debugf("Worker %d: Removed %s\n", id, path)
+	s.stats.Moved.Add(1)
+	s.movedOut(name)
+}
+
+// movedOut records the directories holding name as ones a file was moved
+// out of, up to the source root
+func (s *syncer) movedOut(name string) {
+	s.movedMu.Lock()
+	defer s.movedMu.Unlock()
+	if s.movedDirs == nil {
+		s.movedDirs = make(map[string]bool)
+	}
+	for dir := path.Dir(name); dir != "." && !s.movedDirs[dir]; dir = path.Dir(dir) {
+		s.movedDirs[dir] = true
+	}
+}
+
+// removeEmptySourceDirs removes the source directories emptied by moving
+// their files, deepest first, keeping the source root. Directories that no
+// file was moved out of stay, even when empty.
+func (s *syncer) removeEmptySourceDirs(names []string) {
+	remover := s.src.(removeFS)
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		if !s.movedDirs[name] {
+			continue
+		}
+		if info, err := fs.Stat(s.src, name); err != nil || !info.IsDir() {
+			continue
+		}
+		// Removing fails on directories that still hold files, which is fine
+		remover.Remove(name)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the multi-bar display
+const (
+	multiBarInterval  = 100 * time.Millisecond
+	multiBarWidth     = 30
+	multiBarNameWidth = 30
+)
+
+// activeBars is the multi-bar display currently drawn on the terminal, if any.
+// Messages are printed above it while it is active.
+var activeBars *multiBar
+
+// multiBar draws one progress bar per worker plus a total line, redrawing
+// them in place so that concurrent copies do not garble the terminal
+type multiBar struct {
+	mu      sync.Mutex
+	slots   []barSlot
+	tracker *transferTracker
+	lines   int // lines currently drawn
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// barSlot is the file a worker is copying
+type barSlot struct {
+	name  string
+	size  int64
+	done  int64
+	start time.Time
+}
+
+// useBars reports whether progress is drawn as bars on the terminal
+func useBars() bool {
+	return !*quiet && !*eventsFlag && *progressFormat == progressFormatBar && isTerminal(os.Stdout)
+}
+
+// startMultiBar starts drawing one bar per worker
+func startMultiBar(workers int, tracker *transferTracker) *multiBar {
+	m := &multiBar{
+		slots:   make([]barSlot, workers),
+		tracker: tracker,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	activeBars = m
+	go m.run()
+	return m
+}
+
+func (m *multiBar) run() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(multiBarInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			m.render()
+			m.mu.Unlock()
+		}
+	}
+}
+
+// Stop erases the bars from the terminal
+func (m *multiBar) Stop() {
+	close(m.stop)
+	<-m.stopped
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear()
+	activeBars = nil
+}
+
+// write prints s to w above the bars
+func (m *multiBar) write(w io.Writer, s string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear()
+	io.WriteString(w, s)
+	m.render()
+}
+
+// clear erases the drawn lines; the caller holds m.mu
+func (m *multiBar) clear() {
+	if m.lines > 0 {
+		fmt.Fprintf(os.Stdout, "\x1b[%dA\x1b[J", m.lines)
+		m.lines = 0
+	}
+}
+
+// render redraws every line in place; the caller holds m.mu
+func (m *multiBar) render() {
+	var b strings.Builder
+	if m.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", m.lines)
+	}
+	for i, slot := range m.slots {
+		b.WriteString("\r\x1b[2K" + slot.format(i+1) + "\n")
+	}
+	b.WriteString("\r\x1b[2K" + m.totalLine() + "\n")
+	m.lines = len(m.slots) + 1
+	os.Stdout.WriteString(b.String())
+}
+
+func (m *multiBar) totalLine() string {
+	return fmt.Sprintf("Total: %s / %s at %s/s, ETA %s", formatBytes(m.tracker.done.Load()), formatBytes(m.tracker.total),
+		formatBytes(int64(m.tracker.Rate())), formatETA(m.tracker.ETA()))
+}
+
+func (s barSlot) format(worker int) string {
+	if s.name == "" {
+		return fmt.Sprintf("Worker %d: idle", worker)
+	}
+
+	fraction := 1.0
+	if s.size > 0 {
+		fraction = float64(s.done) / float64(s.size)
+	}
+	filled := int(fraction * multiBarWidth)
+
+	speed := int64(0)
+	if elapsed := time.Since(s.start).Seconds(); elapsed > 0 {
+		speed = int64(float64(s.done) / elapsed)
+	}
+
+	return fmt.Sprintf("Worker %d: %-*s [%s%s] %3.0f%% %s/s", worker, multiBarNameWidth, truncateName(s.name, multiBarNameWidth),
+		strings.Repeat("=", filled), strings.Repeat(" ", multiBarWidth-filled), fraction*100, formatBytes(speed))
+}
+
+// truncateName shortens name to at most width characters
+func truncateName(name string, width int) string {
+	runes := []rune(name)
+	if len(runes) <= width {
+		return name
+	}
+	return string(runes[:width-3]) + "..."
+}
+
+// progress returns the reporter for the file a worker starts copying
+func (m *multiBar) progress(worker int, sourceFile string, size int64) *multiBarProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slots[worker-1] = barSlot{name: filepath.Base(sourceFile), size: size, start: time.Now()}
+	return &multiBarProgress{bars: m, slot: worker - 1}
+}
+
+// multiBarProgress reports the progress of a worker to the multi-bar display
+type multiBarProgress struct {
+	bars *multiBar
+	slot int
+}
+
+func (p *multiBarProgress) Add(n int) {
+	p.bars.tracker.Add(n)
+	p.bars.mu.Lock()
+	p.bars.slots[p.slot].done += int64(n)
+	p.bars.mu.Unlock()
+}
+
+func (p *multiBarProgress) Finish() error {
+	p.bars.mu.Lock()
+	p.bars.slots[p.slot] = barSlot{}
+	p.bars.mu.Unlock()
+	return nil
+}
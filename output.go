@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	quiet   = flag.Bool("quiet", false, "print only errors and the final summary")
+	verbose = flag.Bool("verbose", false, "also explain why files are skipped")
+)
+
+// messageOutput is where human-readable messages go: stdout, unless stdout
+// carries the JSON progress stream or the events
+func messageOutput() io.Writer {
+	if *progressFormat == progressFormatJSON || *eventsFlag {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// ANSI colors of the messages on a terminal
+const (
+	colorNone   = ""
+	colorRed    = "\x1b[31m" // errors
+	colorGreen  = "\x1b[32m" // copied files
+	colorYellow = "\x1b[33m" // skipped files
+	colorReset  = "\x1b[0m"
+)
+
+// colorOutputs records, for stdout and stderr, whether messages written
+// there are colored: only on terminals that handle escape sequences, and
+// never with NO_COLOR set (https://no-color.org)
+var colorOutputs = sync.OnceValue(func() map[io.Writer]bool {
+	colors := make(map[io.Writer]bool)
+	if os.Getenv("NO_COLOR") != "" {
+		return colors
+	}
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		colors[f] = isTerminal(f) && enableVirtualTerminal(f)
+	}
+	return colors
+})
+
+// logf prints a human-readable message, unless running with --quiet
+func logf(format string, args ...interface{}) {
+	logColorf(colorNone, format, args...)
+}
+
+// copiedf is logf for the files copied, in green
+func copiedf(format string, args ...interface{}) {
+	logColorf(colorGreen, format, args...)
+}
+
+// skippedf is debugf for the files skipped, in yellow
+func skippedf(format string, args ...interface{}) {
+	if *verbose {
+		logColorf(colorYellow, format, args...)
+	}
+}
+
+func logColorf(color, format string, args ...interface{}) {
+	if *quiet {
+		return
+	}
+	w := messageOutput()
+	printMessage(w, colorize(w, color, fmt.Sprintf(tr(format), args...)))
+}
+
+// debugf prints a diagnostic message, only with --verbose
+func debugf(format string, args ...interface{}) {
+	if *verbose {
+		logf(format, args...)
+	}
+}
+
+// errorf prints an error message, which --quiet never suppresses
+func errorf(format string, args ...interface{}) {
+	message := fmt.Sprintf(tr(format), args...)
+	printMessage(os.Stderr, colorize(os.Stderr, colorRed, message))
+	emitError(message)
+}
+
+// colorize wraps message in color when w shows colors, leaving out the
+// final line breaks so that the color ends with the text
+func colorize(w io.Writer, color, message string) string {
+	if color == colorNone || !colorOutputs()[w] {
+		return message
+	}
+	text := strings.TrimRight(message, "\n")
+	return color + text + colorReset + message[len(text):]
+}
+
+// printMessage writes a message, keeping it above the progress bars
+func printMessage(w io.Writer, message string) {
+	if bars := activeBars; bars != nil {
+		bars.write(w, message)
+		return
+	}
+	io.WriteString(w, message)
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
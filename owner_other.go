@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// Ownership is not read on this platform, Windows files being owned by SIDs
+const ownersSupported = false
+
+func fileOwner(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
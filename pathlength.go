@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policies for destination paths over max_path
+const (
+	longPathsReport  = "report"  // leave the file out, as an error
+	longPathsShorten = "shorten" // shorten the names that do not fit
+)
+
+// maxNameLength is the longest name of a single file or directory on every
+// supported filesystem
+const maxNameLength = 255
+
+// windowsMaxPath is MAX_PATH less the terminating NUL, which Explorer and
+// most programs are still bound by on disks of target_fs
+const windowsMaxPath = 259
+
+// minShortName is the shortest a name is cut to: a few characters of the
+// original, then "~" and 8 of its hash
+const minShortName = 12
+
+// pathLimit measures destination paths against max_path and the name length
+// limit. It is nil when the destination is not on disk, its paths having no
+// length to measure.
+type pathLimit struct {
+	root  int // length of the destination folder, with its separator
+	max   int
+	utf16 bool
+}
+
+func newPathLimit(dst DestFS, config Config) *pathLimit {
+	local, ok := dst.(localFS)
+	if !ok {
+		return nil
+	}
+	l := &pathLimit{max: config.MaxPath, utf16: utf16Paths || config.TargetFS != ""}
+	l.root = l.length(local.localPath(".")) + 1
+	return l
+}
+
+// length is the length of s in the units of the destination platform
+func (l *pathLimit) length(s string) int {
+	if !l.utf16 {
+		return len(s)
+	}
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2 // surrogate pair
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// tooLong returns why the slash-separated destination name does not fit
+func (l *pathLimit) tooLong(name string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if n := l.length(part); n > maxNameLength {
+			return fmt.Sprintf(tr("the name %.20q... is %d characters long, over %d"), part, n, maxNameLength), true
+		}
+	}
+	// Separators count as one unit, whatever the platform
+	if n := l.root + l.length(name); n > l.max {
+		return fmt.Sprintf(tr("the destination path is %d characters long, over max_path %d"), n, l.max), true
+	}
+	return "", false
+}
+
+// shorten cuts the names of the slash-separated name that go over the
+// limits, each to the room left by the names before it, so that a directory
+// is shortened the same way for all its contents. Names that already fit
+// are left alone, so shortening twice changes nothing more.
+func (l *pathLimit) shorten(name string) string {
+	parts := strings.Split(name, "/")
+	used := l.root
+	for i, part := range parts {
+		room := min(maxNameLength, l.max-used)
+		if l.length(part) > room && room >= minShortName {
+			parts[i] = l.shortName(part, room)
+		}
+		used += l.length(parts[i]) + 1
+	}
+	return strings.Join(parts, "/")
+}
+
+// shortName cuts name to room characters, keeping its extension and adding
+// a hash of the whole name, so that names sharing a long beginning stay
+// distinct
+func (l *pathLimit) shortName(name string, room int) string {
+	sum := sha256.Sum256([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	if ext := path.Ext(name); l.length(ext) <= room-minShortName {
+		suffix += ext
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	stem := room - l.length(suffix)
+	for l.length(name) > stem {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + suffix
+}
+
+// checkPathLength returns why the source entry name is not copied, its
+// destination path not fitting even once shortened
+func (s *syncer) checkPathLength(name string) (string, bool) {
+	if name == "." {
+		return "", false
+	}
+	return s.limit.tooLong(destName(s.dst, name))
+}
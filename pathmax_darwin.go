@@ -0,0 +1,7 @@
+package main
+
+// defaultMaxPath is PATH_MAX, less the terminating NUL
+const defaultMaxPath = 1023
+
+// macOS counts path lengths in bytes
+const utf16Paths = false
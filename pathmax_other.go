@@ -0,0 +1,9 @@
+//go:build !windows && !darwin
+
+package main
+
+// defaultMaxPath is PATH_MAX on Linux, less the terminating NUL
+const defaultMaxPath = 4095
+
+// Unix systems count path lengths in bytes
+const utf16Paths = false
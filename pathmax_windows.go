@@ -0,0 +1,8 @@
+package main
+
+// defaultMaxPath is the longest destination path accepted: Go reaches
+// paths past MAX_PATH through the \\?\ prefix, up to the limit of NTFS
+const defaultMaxPath = 32767
+
+// Windows counts path lengths in UTF-16 code units
+const utf16Paths = true
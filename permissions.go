@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// parseMode parses an octal permission string such as "0644"
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid permission %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// validatePermissions checks the permission options of the config
+func validatePermissions(config Config) error {
+	for _, s := range []string{config.FileMode, config.DirMode, config.Umask} {
+		if s == "" {
+			continue
+		}
+		if _, err := parseMode(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filePermissions returns the mode to force on copied files, if any
+func (c Config) filePermissions() (os.FileMode, bool) {
+	return c.permissions(c.FileMode, 0666)
+}
+
+// dirPermissions returns the mode for created directories and whether it
+// must be forced, ignoring the umask of the process
+func (c Config) dirPermissions() (os.FileMode, bool) {
+	return c.permissions(c.DirMode, os.ModePerm)
+}
+
+func (c Config) permissions(explicit string, base os.FileMode) (os.FileMode, bool) {
+	if explicit != "" {
+		mode, _ := parseMode(explicit)
+		return mode, true
+	}
+	if c.Umask != "" {
+		umask, _ := parseMode(c.Umask)
+		return base &^ umask, true
+	}
+	return base, false
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// preallocateFile reserves disk space in w for the whole of the source file
+// r, so that the copy is laid out contiguously and a full disk shows up
+// before any data is written. It does nothing when either side isn't a
+// local file, or the filesystem can't preallocate.
+func preallocateFile(w io.Writer, r io.Reader) error {
+	var f *os.File
+	switch w := w.(type) {
+	case *os.File:
+		f = w
+	case *directWriter:
+		f = w.f
+	default:
+		return nil
+	}
+
+	source, ok := r.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok {
+		return nil
+	}
+	info, err := source.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	return preallocate(f, info.Size())
+}
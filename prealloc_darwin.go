@@ -0,0 +1,39 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fAllocateContig = 0x2
+	fAllocateAll    = 0x4
+	fPeofPosMode    = 3
+)
+
+// preallocate allocates size bytes to f without changing its length,
+// contiguously if the disk allows it
+func preallocate(f *os.File, size int64) error {
+	store := syscall.Fstore_t{Flags: fAllocateContig | fAllocateAll, Posmode: fPeofPosMode, Length: size}
+	err := fcntlPreallocate(f, &store)
+	if err != nil {
+		store.Flags = fAllocateAll
+		err = fcntlPreallocate(f, &store)
+	}
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+	return err
+}
+
+func fcntlPreallocate(f *os.File, store *syscall.Fstore_t) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_PREALLOCATE, uintptr(unsafe.Pointer(store)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
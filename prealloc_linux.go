@@ -0,0 +1,20 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const fallocKeepSize = 1
+
+// preallocate allocates size bytes to f without changing its length
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "os"
+
+// preallocate is not implemented on this platform
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"unsafe"
+)
+
+const fileAllocationInfo = 5
+
+var procSetFileInformationByHandle = modkernel32.NewProc("SetFileInformationByHandle")
+
+// preallocate allocates size bytes to f without changing its length
+func preallocate(f *os.File, size int64) error {
+	info := struct{ AllocationSize int64 }{size}
+	r, _, err := procSetFileInformationByHandle.Call(f.Fd(), fileAllocationInfo, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
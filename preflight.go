@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Values accepted by the space_check option
+const (
+	spaceCheckAbort = "abort" // refuse to start a sync that cannot fit (default)
+	spaceCheckWarn  = "warn"  // report it and sync anyway
+	spaceCheckOff   = "off"
+)
+
+// errUnsupported is returned by checks this platform cannot perform
+var errUnsupported = errors.New("not supported on this platform")
+
+// checkDestination makes sure the destination exists, creating it when
+// needed, is a directory and can be written to
+func checkDestination(dst DestFS, config Config) error {
+	path := displayPath(dst, ".")
+	mode, _ := config.dirPermissions()
+	if err := dst.MkdirAll(".", mode); err != nil {
+		return fmt.Errorf("cannot create destination %s: %v", path, err)
+	}
+
+	info, err := dst.Stat(".")
+	if err != nil {
+		return fmt.Errorf("cannot access destination %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("destination %s is not a directory", path)
+	}
+
+	probe := fmt.Sprintf(".gosync-probe-%d", os.Getpid())
+	f, err := dst.Create(probe)
+	if err != nil {
+		return fmt.Errorf("destination %s is not writable: %v", path, err)
+	}
+	f.Close()
+	if err := dst.Remove(probe); err != nil {
+		return fmt.Errorf("cannot remove probe file from destination %s: %v", path, err)
+	}
+	return nil
+}
+
+// checkFreeSpace compares the space and the number of files the scanned
+// files need with what the destination filesystem has left
+func checkFreeSpace(dst DestFS, scan scanResult, config Config) error {
+	local, ok := dst.(localFS)
+	if !ok || config.SpaceCheck == spaceCheckOff {
+		return nil
+	}
+	path := local.localPath(".")
+
+	if scan.Growth > 0 {
+		free, err := freeSpace(path)
+		if err != nil && err != errUnsupported {
+			errorf("Cannot check free space on %s: %v\n", path, err)
+		}
+		if err == nil && uint64(scan.Growth) > free {
+			return spaceCheckFailed(config, fmt.Errorf("not enough free space on %s: %s needed, %s available", path, formatBytes(scan.Growth), formatBytes(int64(free))))
+		}
+	}
+
+	if scan.Created > 0 {
+		free, err := freeInodes(path)
+		if err != nil && err != errUnsupported {
+			errorf("Cannot check free inodes on %s: %v\n", path, err)
+		}
+		if err == nil && uint64(scan.Created) > free {
+			return spaceCheckFailed(config, fmt.Errorf("not enough free inodes on %s: %d files to create, %d available", path, scan.Created, free))
+		}
+	}
+	return nil
+}
+
+// spaceCheckFailed applies the space_check policy to a failed check
+func spaceCheckFailed(config Config, err error) error {
+	if config.SpaceCheck == spaceCheckWarn {
+		errorf("Warning: %v\n", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build freebsd || dragonfly || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+// lowerPriority gives the process the lowest CPU priority; there is no
+// separate I/O priority on the BSDs
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19)
+}
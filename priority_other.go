@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !netbsd && !openbsd && !windows
+
+package main
+
+// lowerPriority is not implemented on this platform
+func lowerPriority() error {
+	return errUnsupported
+}
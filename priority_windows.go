@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const processModeBackgroundBegin = 0x00100000
+
+var procSetPriorityClass = modkernel32.NewProc("SetPriorityClass")
+
+// lowerPriority enters the background processing mode, which lowers the CPU,
+// I/O and memory priority of the process
+func lowerPriority() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if r, _, err := procSetPriorityClass.Call(uintptr(process), processModeBackgroundBegin); r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// Values accepted by --progress-format
+const (
+	progressFormatBar  = "bar"
+	progressFormatJSON = "json"
+)
+
+// progress reports the advance of a single file copy
+type progress interface {
+	Add(n int)
+	Finish() error
+}
+
+// newProgress creates the progress reporter selected by --progress-format.
+// Progress is not reported with --quiet, nor as bars when stdout is not a
+// terminal, so that cron mails do not fill up with escape codes.
+func newProgress(worker int, sourceFile string, size int64, tracker *transferTracker) progress {
+	switch {
+	case activeBars != nil:
+		return activeBars.progress(worker, sourceFile, size)
+	case *quiet, *eventsFlag:
+		return noProgress{tracker}
+	case *progressFormat == progressFormatJSON:
+		return newJSONProgress(sourceFile, size, tracker)
+	case !isTerminal(os.Stdout):
+		return noProgress{tracker}
+	}
+	return newBarProgress(sourceFile, size, tracker)
+}
+
+// noProgress only feeds the job-wide tracker
+type noProgress struct {
+	tracker *transferTracker
+}
+
+func (p noProgress) Add(n int)   { p.tracker.Add(n) }
+func (noProgress) Finish() error { return nil }
+
+// barProgress draws a progress bar on the terminal
+type barProgress struct {
+	bar     *progressbar.ProgressBar
+	name    string
+	start   time.Time
+	tracker *transferTracker
+}
+
+func newBarProgress(sourceFile string, size int64, tracker *transferTracker) *barProgress {
+	bar := progressbar.NewOptions64(
+		size,
+		progressbar.OptionSetDescription(fmt.Sprintf("Copying %s", filepath.Base(sourceFile))),
+		progressbar.OptionSetWriter(os.Stdout),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionThrottle(65*time.Millisecond),
+		progressbar.OptionSetWidth(40),
+		progressbar.OptionClearOnFinish(),
+	)
+	return &barProgress{bar: bar, name: filepath.Base(sourceFile), start: time.Now(), tracker: tracker}
+}
+
+func (p *barProgress) Add(n int) {
+	p.bar.Add(n)
+	p.tracker.Add(n)
+
+	elapsed := time.Since(p.start).Seconds()
+	speed := float64(p.bar.State().CurrentBytes) / elapsed
+	p.bar.Describe(fmt.Sprintf("%s (%.2f KB/s, total ETA %s)", p.name, speed/1024, formatETA(p.tracker.ETA())))
+}
+
+func (p *barProgress) Finish() error {
+	return p.bar.Finish()
+}
+
+// progressEvent is a line of the JSON progress stream
+type progressEvent struct {
+	File  string  `json:"file"`
+	Bytes int64   `json:"bytes"`
+	Total int64   `json:"total"`
+	Speed float64 `json:"speed"` // bytes per second
+	ETA   float64 `json:"eta"`   // seconds
+	Done  bool    `json:"done"`
+
+	TotalSpeed float64 `json:"total_speed,omitempty"` // bytes per second across all workers
+	TotalETA   float64 `json:"total_eta,omitempty"`   // seconds left for the whole job
+}
+
+// jsonMu keeps events of concurrent workers on separate lines
+var jsonMu sync.Mutex
+
+// jsonEventInterval throttles the events emitted for a single file
+const jsonEventInterval = 250 * time.Millisecond
+
+// jsonProgress writes newline-delimited JSON progress events to stdout
+type jsonProgress struct {
+	file      string
+	total     int64
+	bytes     int64
+	start     time.Time
+	lastEvent time.Time
+	tracker   *transferTracker
+}
+
+func newJSONProgress(sourceFile string, size int64, tracker *transferTracker) *jsonProgress {
+	p := &jsonProgress{file: sourceFile, total: size, start: time.Now(), tracker: tracker}
+	p.emit(false)
+	return p
+}
+
+func (p *jsonProgress) Add(n int) {
+	p.bytes += int64(n)
+	p.tracker.Add(n)
+	if time.Since(p.lastEvent) >= jsonEventInterval {
+		p.emit(false)
+	}
+}
+
+func (p *jsonProgress) Finish() error {
+	return p.emit(true)
+}
+
+func (p *jsonProgress) emit(done bool) error {
+	p.lastEvent = time.Now()
+
+	event := progressEvent{File: p.file, Bytes: p.bytes, Total: p.total, Done: done}
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 && p.bytes > 0 {
+		event.Speed = float64(p.bytes) / elapsed
+		event.ETA = float64(p.total-p.bytes) / event.Speed
+	}
+	event.TotalSpeed = p.tracker.Rate()
+	if eta, ok := p.tracker.ETA(); ok {
+		event.TotalETA = eta.Seconds()
+	}
+
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	return json.NewEncoder(os.Stdout).Encode(event)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// checkProxy checks that proxy is the URL of an HTTP or SOCKS5 proxy
+func checkProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("must be an http://, https:// or socks5:// URL such as socks5://proxy:1080")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("the URL has no host")
+	}
+	return nil
+}
+
+// applyProxy sends the network connections of the run through proxy, over
+// HTTP_PROXY and HTTPS_PROXY. Both the trace exporter and rclone read them,
+// and NO_PROXY still exempts hosts from the proxy.
+func applyProxy(config Config) {
+	if config.Proxy == "" {
+		return
+	}
+	os.Setenv("HTTP_PROXY", config.Proxy)
+	os.Setenv("HTTPS_PROXY", config.Proxy)
+}
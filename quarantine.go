@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Failure is a file that could not be copied in the last runs
+type Failure struct {
+	Runs        int       `json:"runs"` // failed runs in a row
+	Error       string    `json:"error"`
+	Last        time.Time `json:"last"`
+	Quarantined time.Time `json:"quarantined,omitempty"` // zero while still retried
+}
+
+// recordFailure counts a failed run for name, putting it in quarantine once
+// it failed after runs in a row; 0 never quarantines
+func (s *State) recordFailure(name string, err error, after int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failure := s.Failures[name]
+	failure.Runs++
+	failure.Error, failure.Last = err.Error(), time.Now()
+	if after > 0 && failure.Runs >= after && failure.Quarantined.IsZero() {
+		failure.Quarantined = failure.Last
+	}
+	s.Failures[name] = failure
+}
+
+// quarantined returns the failure of name if it is in quarantine
+func (s *State) quarantined(name string) (Failure, bool) {
+	if s == nil {
+		return Failure{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failure, ok := s.Failures[name]
+	return failure, ok && !failure.Quarantined.IsZero()
+}
+
+// quarantine returns the names in quarantine, sorted
+func (s *State) quarantine() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name, failure := range s.Failures {
+		if !failure.Quarantined.IsZero() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reportQuarantine reminds at the end of a run of the files left out
+func (s *syncer) reportQuarantine() {
+	names := s.state.quarantine()
+	if len(names) == 0 {
+		return
+	}
+	errorf("%d files are quarantined and were not copied; clear them with sync.exe quarantine -clear:\n", len(names))
+	for _, name := range names {
+		failure, _ := s.state.quarantined(name)
+		errorf("  %s: %s\n", displayPath(s.src, name), failure.Error)
+	}
+}
+
+// runQuarantine is the quarantine command: it lists the files that failed
+// in the last runs, or with -clear takes them out of quarantine to be
+// retried by the next run, all of them or those under the given paths
+func runQuarantine(config Config, args []string) error {
+	flags := flag.NewFlagSet("quarantine", flag.ExitOnError)
+	clear := flags.Bool("clear", false, "retry the quarantined files on the next run")
+	flags.Parse(args)
+
+	if config.StateFile == "" {
+		return fmt.Errorf("the quarantine is kept in the state_file, which is not set")
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Errorf("cannot read state file: %v", err)
+	}
+
+	selected := func(name string) bool {
+		if flags.NArg() == 0 {
+			return true
+		}
+		for _, arg := range flags.Args() {
+			arg = path.Clean(strings.ReplaceAll(arg, `\`, "/"))
+			if arg == "." || name == arg || strings.HasPrefix(name, arg+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if *clear {
+		cleared := 0
+		for _, name := range state.quarantine() {
+			if selected(name) {
+				delete(state.Failures, name)
+				cleared++
+			}
+		}
+		if err := state.save(config.StateFile); err != nil {
+			return fmt.Errorf("cannot write state file: %v", err)
+		}
+		logf("%d files taken out of quarantine\n", cleared)
+		return nil
+	}
+
+	names := make([]string, 0, len(state.Failures))
+	for name := range state.Failures {
+		if selected(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, tr("NAME\tRUNS\tQUARANTINED\tLAST ERROR"))
+	for _, name := range names {
+		failure := state.Failures[name]
+		quarantined := "-"
+		if !failure.Quarantined.IsZero() {
+			quarantined = failure.Quarantined.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, failure.Runs, quarantined, failure.Error)
+	}
+	return w.Flush()
+}
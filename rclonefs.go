@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rclonePrefix marks a destination reached through rclone, followed by a
+// remote of rclone.conf and its path, as in "rclone:gdrive:backups"
+const rclonePrefix = "rclone:"
+
+// rcloneModifyWindow is how far apart the times of the source and of the
+// remote may be and still be equal, since most remotes keep whole seconds
+const rcloneModifyWindow = time.Second
+
+// rcloneNotFound is the exit status of rclone for a missing directory
+const rcloneNotFound = 3
+
+// defaultListWorkers is how many folders of a remote are listed at once
+// when list_workers is not set
+const defaultListWorkers = 8
+
+// rcloneFS is a DestFS on an rclone remote, driving the rclone command. The
+// listings of the directories are cached, and kept up to date with what the
+// run changes, so that a directory is listed only once.
+type rcloneFS struct {
+	bin    string
+	remote string
+	mu     sync.Mutex                // over dirs and the entries of their listings
+	dirs   map[string]*rcloneListing // by directory
+}
+
+// rcloneListing is the listing of a directory, ready once done is closed
+type rcloneListing struct {
+	done    chan struct{}
+	time    time.Time
+	entries map[string]*rcloneInfo // by name
+	err     error
+}
+
+// rcloneInfo is an entry of rclone lsjson
+type rcloneInfo struct {
+	Base   string    `json:"Name"`
+	Bytes  int64     `json:"Size"`
+	Time   time.Time `json:"ModTime"`
+	Folder bool      `json:"IsDir"`
+}
+
+func (i *rcloneInfo) Name() string       { return i.Base }
+func (i *rcloneInfo) Size() int64        { return i.Bytes }
+func (i *rcloneInfo) ModTime() time.Time { return i.Time }
+func (i *rcloneInfo) IsDir() bool        { return i.Folder }
+func (i *rcloneInfo) Sys() any           { return nil }
+
+func (i *rcloneInfo) Mode() fs.FileMode {
+	if i.Folder {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func newRcloneFS(bin, remote string) *rcloneFS {
+	if bin == "" {
+		bin = "rclone"
+	}
+	return &rcloneFS{bin: bin, remote: remote, dirs: make(map[string]*rcloneListing)}
+}
+
+// path is the rclone path of name
+func (f *rcloneFS) path(name string) string {
+	if name == "." {
+		return f.remote
+	}
+	if strings.HasSuffix(f.remote, ":") || strings.HasSuffix(f.remote, "/") {
+		return f.remote + name
+	}
+	return f.remote + "/" + name
+}
+
+// run runs rclone with args, returning its output
+func (f *rcloneFS) run(args ...string) ([]byte, error) {
+	sp := startSpan("rclone "+args[0], nil, attr("gosync.path", args[len(args)-1]))
+	cmd := exec.Command(f.bin, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		err = rcloneError(args[0], err, stderr.String())
+		if errors.Is(err, fs.ErrNotExist) {
+			sp.set("gosync.missing", true)
+			sp.end(nil) // an answer, not a failure
+		} else {
+			sp.end(err)
+		}
+		return nil, err
+	}
+	sp.end(nil)
+	return stdout.Bytes(), nil
+}
+
+// rcloneError describes a failed rclone command with its last message
+func rcloneError(command string, err error, stderr string) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == rcloneNotFound {
+		return fs.ErrNotExist
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if message := lines[len(lines)-1]; message != "" {
+		return fmt.Errorf("rclone %s: %s", command, message)
+	}
+	return fmt.Errorf("rclone %s: %v", command, err)
+}
+
+// list returns the listing of the directory dir, waiting for the listing
+// under way when there is one. Its entries are read under f.mu.
+func (f *rcloneFS) list(dir string) (*rcloneListing, error) {
+	f.mu.Lock()
+	l, ok := f.dirs[dir]
+	if !ok {
+		l = &rcloneListing{done: make(chan struct{})}
+		f.dirs[dir] = l
+	}
+	f.mu.Unlock()
+	if ok {
+		<-l.done
+		return l, l.err
+	}
+
+	defer close(l.done)
+	l.time = time.Now()
+	l.entries, l.err = f.lsjson(dir)
+	if l.err != nil {
+		// Listed again by the next caller, rather than failing for the run
+		f.mu.Lock()
+		if f.dirs[dir] == l {
+			delete(f.dirs, dir)
+		}
+		f.mu.Unlock()
+	}
+	return l, l.err
+}
+
+// entry returns the entry name of the directory dir, nil when missing
+func (f *rcloneFS) entry(dir, name string) (*rcloneInfo, error) {
+	l, err := f.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return l.entries[name], nil
+}
+
+func (f *rcloneFS) lsjson(dir string) (map[string]*rcloneInfo, error) {
+	out, err := f.run("lsjson", f.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var list []*rcloneInfo
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("rclone lsjson: %v", err)
+	}
+	entries := make(map[string]*rcloneInfo, len(list))
+	for _, entry := range list {
+		entries[entry.Base] = entry
+	}
+	return entries, nil
+}
+
+// listTree lists every directory of the remote, workers at a time, so that
+// the scan finds the listings it needs ready or under way instead of
+// waiting for rclone one folder after the other. The folders found wait in
+// a queue for one of the workers.
+func (f *rcloneFS) listTree(workers int) {
+	var mu sync.Mutex
+	found := sync.NewCond(&mu)
+	queue, busy := []string{"."}, 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			for {
+				for len(queue) == 0 && busy > 0 {
+					found.Wait()
+				}
+				if len(queue) == 0 {
+					found.Broadcast() // the tree is listed
+					return
+				}
+				dir := queue[0]
+				queue = queue[1:]
+				busy++
+				mu.Unlock()
+				folders := f.folders(dir)
+				mu.Lock()
+				busy--
+				queue = append(queue, folders...)
+				found.Broadcast()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// folders lists dir and returns the folders in it. Errors are reported by
+// the scan when it needs the folder.
+func (f *rcloneFS) folders(dir string) []string {
+	l, err := f.list(dir)
+	if err != nil {
+		return nil
+	}
+	var folders []string
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, entry := range l.entries {
+		if entry.Folder {
+			folders = append(folders, path.Join(dir, name))
+		}
+	}
+	return folders
+}
+
+// prefetchListings starts listing the tree of dst in the background when it
+// is a remote, returning the function waiting for it to finish
+func prefetchListings(dst DestFS, workers int) (wait func()) {
+	remote, ok := baseDest(dst).(*rcloneFS)
+	if !ok {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		remote.listTree(workers)
+	}()
+	return func() { <-done }
+}
+
+// changed drops the cached listing of the directory holding name, after
+// a command that failed left it in a state only rclone knows
+func (f *rcloneFS) changed(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.dirs, path.Dir(name))
+	if name == "." {
+		clear(f.dirs)
+	}
+}
+
+// listed returns the finished listing of dir, if any, under f.mu. One still
+// under way is dropped, as it may miss the change about to be recorded.
+func (f *rcloneFS) listed(dir string) *rcloneListing {
+	l, ok := f.dirs[dir]
+	if !ok {
+		return nil
+	}
+	select {
+	case <-l.done:
+	default:
+		delete(f.dirs, dir)
+		return nil
+	}
+	if l.err != nil {
+		return nil
+	}
+	return l
+}
+
+// update records info as the entry name in the listing of its directory,
+// as rclone would now list it
+func (f *rcloneFS) update(name string, info *rcloneInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if l := f.listed(path.Dir(name)); l != nil {
+		l.entries[path.Base(name)] = info
+	}
+}
+
+// removed drops the entry name from the listing of its directory, and the
+// listings below it when it is a folder
+func (f *rcloneFS) removed(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if l := f.listed(path.Dir(name)); l != nil {
+		delete(l.entries, path.Base(name))
+	}
+	for dir := range f.dirs {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			delete(f.dirs, dir)
+		}
+	}
+}
+
+func (f *rcloneFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		if _, err := f.list("."); err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: f.path(name), Err: err}
+		}
+		return &rcloneInfo{Folder: true, Base: "."}, nil
+	}
+	info, err := f.entry(path.Dir(name), path.Base(name))
+	if err == nil && info == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.path(name), Err: err}
+	}
+	return info, nil
+}
+
+func (f *rcloneFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	l, err := f.list(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: f.path(name), Err: err}
+	}
+	f.mu.Lock()
+	list := make([]fs.DirEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		list = append(list, fs.FileInfoToDirEntry(entry))
+	}
+	f.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+func (f *rcloneFS) Open(name string) (fs.File, error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &rcloneDir{f, name, info}, nil
+	}
+
+	cmd := exec.Command(f.bin, "cat", f.path(name))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &rcloneReader{stdout, cmd, &stderr, info}, nil
+}
+
+func (f *rcloneFS) MkdirAll(name string, perm fs.FileMode) error {
+	if _, err := f.Stat(name); err == nil {
+		return nil
+	}
+	if _, err := f.run("mkdir", f.path(name)); err != nil {
+		f.changed(name)
+		return err
+	}
+
+	// The new folder is empty, and each of its parents now holds a folder
+	f.mu.Lock()
+	f.dirs[name] = &rcloneListing{done: make(chan struct{}), time: time.Now(), entries: make(map[string]*rcloneInfo)}
+	close(f.dirs[name].done)
+	f.mu.Unlock()
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		f.mu.Lock()
+		if l := f.listed(path.Dir(dir)); l != nil && l.entries[path.Base(dir)] == nil {
+			l.entries[path.Base(dir)] = &rcloneInfo{Base: path.Base(dir), Time: time.Now(), Folder: true}
+		}
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *rcloneFS) Create(name string) (io.WriteCloser, error) {
+	cmd := exec.Command(f.bin, "rcat", f.path(name))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &rcloneWriter{WriteCloser: stdin, cmd: cmd, stderr: &stderr, f: f, name: name}, nil
+}
+
+// Chmod does nothing, as remotes keep no permissions
+func (f *rcloneFS) Chmod(name string, mode fs.FileMode) error {
+	return nil
+}
+
+// Chtimes sets the modification time of files only: most remotes have no
+// directories to keep times on
+func (f *rcloneFS) Chtimes(name string, atime, mtime time.Time) error {
+	info, err := f.Stat(name)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+	if _, err := f.run("touch", "--no-create", "--timestamp", mtime.UTC().Format("2006-01-02T15:04:05"), f.path(name)); err != nil {
+		f.changed(name)
+		return err
+	}
+	if entry, ok := info.(*rcloneInfo); ok {
+		touched := *entry
+		touched.Time = mtime.UTC().Truncate(time.Second) // as the timestamp was given
+		f.update(name, &touched)
+	}
+	return nil
+}
+
+func (f *rcloneFS) Remove(name string) error {
+	info, err := f.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		_, err = f.run("rmdir", f.path(name))
+	} else {
+		_, err = f.run("deletefile", f.path(name))
+	}
+	if err != nil {
+		f.changed(name)
+		return err
+	}
+	f.removed(name)
+	return nil
+}
+
+// modifyWindow lets the comparisons ignore the times the remote truncates
+func (f *rcloneFS) modifyWindow() time.Duration {
+	return rcloneModifyWindow
+}
+
+// rcloneDir is a directory opened on an rcloneFS
+type rcloneDir struct {
+	f    *rcloneFS
+	name string
+	info fs.FileInfo
+}
+
+func (d *rcloneDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *rcloneDir) Read([]byte) (int, error)   { return 0, fmt.Errorf("%s is a directory", d.name) }
+func (d *rcloneDir) Close() error               { return nil }
+
+func (d *rcloneDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	return d.f.ReadDir(d.name)
+}
+
+// rcloneReader reads a file of the remote through rclone cat
+type rcloneReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	info   fs.FileInfo
+}
+
+func (r *rcloneReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+
+func (r *rcloneReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return rcloneError("cat", err, r.stderr.String())
+	}
+	return nil
+}
+
+// rcloneWriter writes a file of the remote through rclone rcat, which
+// stores it once the input is closed
+type rcloneWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	f      *rcloneFS
+	name   string
+	size   int64 // written so far
+}
+
+func (w *rcloneWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close records the stored file in the listing of its folder, with the time
+// of the upload until Chtimes sets the one of the source
+func (w *rcloneWriter) Close() error {
+	if w.cmd == nil {
+		return nil // closed already
+	}
+	w.WriteCloser.Close()
+	err := w.cmd.Wait()
+	w.cmd = nil
+	if err != nil {
+		w.f.changed(w.name)
+		return rcloneError("rcat", err, w.stderr.String())
+	}
+	w.f.update(w.name, &rcloneInfo{Base: path.Base(w.name), Bytes: w.size, Time: time.Now()})
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var errRenamesUnsupported = errors.New("detect_renames requires a destination on disk")
+
+// plannedRename is a destination copy that detectRenames found under the
+// old name of a file, to be renamed once the run is confirmed
+type plannedRename struct {
+	old, name string
+	info      fs.FileInfo // of the source file
+	hash      string
+}
+
+// detectRenames looks among the files of the scan that are new to the
+// destination for the content of a synced file gone from the source, whose
+// destination copy can be renamed to the new name instead of copying the
+// file again. Candidates have the same size, and their content is confirmed
+// with the recorded hash, or by hashing the destination copy. Nothing is
+// renamed yet: the totals of the scan leave the renamed files out, and
+// applyRenames renames them, putting back those it could not.
+func (s *syncer) detectRenames(scan *scanResult) []plannedRename {
+	gone := make(map[int64][]string) // by size
+	s.state.mu.Lock()
+	for name, file := range s.state.Files {
+		if file.Size > 0 && !scan.inSource(name) {
+			gone[file.Size] = append(gone[file.Size], name)
+		}
+	}
+	s.state.mu.Unlock()
+	if len(gone) == 0 {
+		return nil
+	}
+
+	var renames []plannedRename
+	claimed := make(map[string]bool)
+	for _, name := range scan.Paths {
+		info, err := fs.Stat(s.src, name)
+		if err != nil || !info.Mode().IsRegular() || len(gone[info.Size()]) == 0 {
+			continue
+		}
+		if _, excluded := s.filter.exclude(name, info); excluded {
+			continue
+		}
+		if _, long := s.checkPathLength(name); long {
+			continue
+		}
+		if _, err := s.dst.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		sum, err := hashFile(s.src, name, s.config.Hash)
+		if err != nil {
+			continue
+		}
+		for _, old := range gone[info.Size()] {
+			if claimed[old] || !s.sameAsSynced(old, sum) {
+				continue
+			}
+			claimed[old] = true
+			renames = append(renames, plannedRename{old, name, info, formatHash(s.config.Hash, sum)})
+			scan.Files--
+			scan.Bytes -= info.Size()
+			scan.Growth -= info.Size()
+			scan.Created--
+			break
+		}
+	}
+	return renames
+}
+
+// applyRenames renames the destination copies of renames, returning how
+// many failed. A file that cannot be renamed is copied by the workers
+// instead, and its old copy deleted, so it goes back into the totals.
+func (s *syncer) applyRenames(scan *scanResult, renames []plannedRename) int64 {
+	local := s.dst.(localFS)
+	var failed int64
+	for _, r := range renames {
+		if s.renameEntry(local, r.old, r.name, r.info, r.hash) {
+			continue
+		}
+		failed++
+		scan.Files++
+		scan.Bytes += r.info.Size()
+		scan.Growth += r.info.Size()
+		scan.Created++
+	}
+	return failed
+}
+
+// sameAsSynced reports whether the destination copy of the synced file
+// name has the hash sum
+func (s *syncer) sameAsSynced(name string, sum []byte) bool {
+	s.state.mu.Lock()
+	file := s.state.Files[name]
+	s.state.mu.Unlock()
+
+	info, err := s.dst.Stat(name)
+	if err != nil || info.Size() != file.Size {
+		return false
+	}
+	if recorded, ok := parseHash(file.Hash, s.config.Hash); ok {
+		return bytes.Equal(recorded, sum)
+	}
+	destSum, err := hashFile(s.dst, name, s.config.Hash)
+	return err == nil && bytes.Equal(destSum, sum)
+}
+
+// renameEntry renames the destination copy of old to name, the source file
+// described by info, moving its record in the state DB along. It returns
+// whether the rename was done.
+func (s *syncer) renameEntry(local localFS, old, name string, info fs.FileInfo, hash string) bool {
+	from, to := local.localPath(old), local.localPath(name)
+	mode, _ := s.config.dirPermissions()
+	seq := s.journal.begin(journalEntry{Op: opRename, Path: name, From: old})
+	err := os.MkdirAll(filepath.Dir(to), mode)
+	if err == nil {
+		err = os.Rename(from, to)
+	}
+	s.journal.end(seq, err)
+	if err != nil {
+		errorf("Error renaming %s to %s: %v\n", from, to, err)
+		s.stats.addError()
+		return false
+	}
+
+	logf("Renamed %s to %s instead of copying it\n", from, to)
+	s.stats.Renamed.Add(1)
+	s.report.add(actionRenamed, name, info.Size(), 0, fmt.Sprintf(tr("from %s"), old))
+	s.state.forget(old)
+	s.state.recordSynced(name, info, hash)
+	s.meta.forget(old)
+	s.meta.record(name, info)
+	if err := preserveFileTimes(info, s.dst, name, s.config.PreserveTimes); err != nil {
+		errorf("Error setting times for %s: %v\n", to, err)
+		s.stats.addError()
+	}
+	return true
+}
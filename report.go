@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Actions of the report_file
+const (
+	actionCopied   = "copied"
+	actionSkipped  = "skipped"
+	actionExcluded = "excluded"
+	actionDeleted  = "deleted"
+	actionRenamed  = "renamed"
+	actionFailed   = "failed"
+)
+
+// reportEntry is an action of the run on one entry
+type reportEntry struct {
+	Time     time.Time
+	Action   string
+	Name     string
+	Bytes    int64
+	Duration time.Duration
+	Detail   string // reason or error
+}
+
+// runReport records every action of a run: to the CSV report_file, and
+// the failures in memory for the html_report
+type runReport struct {
+	mu       sync.Mutex
+	file     *os.File
+	w        *csv.Writer // nil without a report_file
+	keep     bool        // whether failures are kept
+	failures []reportEntry
+}
+
+// newRunReport starts the reports of config, returning nil without any.
+// The report_file of the previous run is replaced.
+func newRunReport(config Config) (*runReport, error) {
+	if config.ReportFile == "" && config.HTMLReport == "" {
+		return nil, nil
+	}
+	r := &runReport{keep: config.HTMLReport != ""}
+	if config.ReportFile != "" {
+		f, err := os.Create(config.ReportFile)
+		if err != nil {
+			return nil, err
+		}
+		r.file, r.w = f, csv.NewWriter(f)
+		r.w.Write([]string{"time", "action", "path", "bytes", "seconds", "detail"})
+	}
+	return r, nil
+}
+
+// add records action on the entry name, with the bytes it moved, how long
+// it took and its reason or error
+func (r *runReport) add(action, name string, bytes int64, duration time.Duration, detail string) {
+	if r == nil {
+		return
+	}
+	entry := reportEntry{time.Now(), action, name, bytes, duration, detail}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keep && action == actionFailed {
+		r.failures = append(r.failures, entry)
+	}
+	if r.w == nil {
+		return
+	}
+	r.w.Write([]string{
+		entry.Time.Format(time.RFC3339),
+		action,
+		name,
+		strconv.FormatInt(bytes, 10),
+		strconv.FormatFloat(duration.Seconds(), 'f', -1, 64),
+		detail,
+	})
+}
+
+// Failures returns the failed actions, with html_report
+func (r *runReport) Failures() []reportEntry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]reportEntry(nil), r.failures...)
+}
+
+func (r *runReport) close() error {
+	if r == nil || r.w == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
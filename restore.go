@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// latestSnapshotID names the newest snapshot in the restore command
+const latestSnapshotID = "latest"
+
+var errNotChunkStore = errors.New("the destination is not a chunk store: destination_format is not chunks")
+
+// runSnapshots is the snapshots command: it lists the snapshots of the
+// chunk store, oldest first
+func runSnapshots(config Config) error {
+	if config.DestinationFormat != formatChunks {
+		return errNotChunkStore
+	}
+	store, err := openChunkStore(config.Destination, config.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	ids, err := store.snapshots()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, tr("SNAPSHOT\tFILES\tSIZE\tSOURCE"))
+	for _, id := range ids {
+		snap, err := store.loadSnapshot(id)
+		if err != nil {
+			return err
+		}
+		var files, size int64
+		for _, entry := range snap.Files {
+			if !entry.Dir {
+				files++
+				size += entry.Size
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", id, files, formatBytes(size), snap.Source)
+	}
+	return w.Flush()
+}
+
+// runRestore is the restore command: it rebuilds the tree of a snapshot of
+// the chunk store, or of the latest one, into the target folder, with the
+// permissions and modification times of the files
+func runRestore(config Config, args []string) error {
+	if config.DestinationFormat != formatChunks {
+		return errNotChunkStore
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: sync.exe restore <snapshot|latest> <folder>")
+	}
+	store, err := openChunkStore(config.Destination, config.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	id, target := args[0], args[1]
+	if id == latestSnapshotID {
+		ids, err := store.snapshots()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("no snapshot to restore")
+		}
+		id = ids[len(ids)-1]
+	}
+	snap, err := store.loadSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	var restored, failed int
+	for _, entry := range snap.Files {
+		path := filepath.Join(target, filepath.FromSlash(entry.Path))
+		var err error
+		if entry.Dir {
+			err = os.MkdirAll(path, entry.Mode.Perm()|0700)
+		} else {
+			err = restoreFile(store, entry, path)
+		}
+		if err != nil {
+			errorf("Error restoring %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		debugf("Restored %s\n", path)
+		restored++
+	}
+
+	// Directories last, as restoring their files changed their times
+	for i := len(snap.Files) - 1; i >= 0; i-- {
+		if entry := snap.Files[i]; entry.Dir {
+			path := filepath.Join(target, filepath.FromSlash(entry.Path))
+			os.Chmod(path, entry.Mode.Perm())
+			os.Chtimes(path, entry.ModTime, entry.ModTime)
+		}
+	}
+
+	logf("Restored %d entries of snapshot %s to %s, %d errors\n", restored, id, target, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d entries could not be restored", failed)
+	}
+	return nil
+}
+
+// restoreFile writes the file of entry at path from its chunks
+func restoreFile(store *chunkStore, entry snapshotEntry, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	for _, id := range entry.Chunks {
+		data, err := store.get(id)
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, entry.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, entry.ModTime, entry.ModTime)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// versionedBackups reports whether each run keeps its backups in a folder
+// of its own, under the retention policy
+func (c Config) versionedBackups() bool {
+	return c.BackupKeep > 0 || c.BackupKeepDaily > 0 || c.BackupKeepWeekly > 0
+}
+
+// retainedRuns returns the run folders of backup_dir, or the snapshots of a
+// chunk store, that the retention policy keeps: the newest backup_keep
+// runs, the newest run of each of the last backup_keep_daily days that have
+// runs and the newest run of each of the last backup_keep_weekly such
+// weeks. Days without runs do not count, so that a machine left off for a
+// while keeps its last backups. The times of the runs are read in the
+// location of now.
+func retainedRuns(runs []string, config Config, now time.Time) map[string]bool {
+	sorted := append([]string(nil), runs...)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted))) // newest first
+
+	keep := make(map[string]bool)
+	for i := 0; i < len(sorted) && i < config.BackupKeep; i++ {
+		keep[sorted[i]] = true
+	}
+
+	days, weeks := make(map[string]bool), make(map[string]bool)
+	for _, run := range sorted {
+		// Snapshots of the same second are numbered after the time
+		t, err := time.ParseInLocation(backupRunFormat, run[:min(len(run), len(backupRunFormat))], now.Location())
+		if err != nil {
+			continue
+		}
+		day := t.Format("2006-01-02")
+		if len(days) < config.BackupKeepDaily && !days[day] {
+			days[day] = true
+			keep[run] = true
+		}
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+		if len(weeks) < config.BackupKeepWeekly && !weeks[weekKey] {
+			weeks[weekKey] = true
+			keep[run] = true
+		}
+	}
+	return keep
+}
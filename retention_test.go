@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRetainedRuns(t *testing.T) {
+	// A Wednesday: its week started on Monday 2026-10-12
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config Config
+		runs   []string
+		want   []string
+	}{
+		{
+			name:   "newest runs",
+			config: Config{BackupKeep: 2},
+			runs:   []string{"2026-10-12_080000", "2026-10-14_080000", "2026-10-13_080000", "2026-10-11_080000"},
+			want:   []string{"2026-10-13_080000", "2026-10-14_080000"},
+		},
+		{
+			name:   "newest run of each day",
+			config: Config{BackupKeepDaily: 2},
+			runs:   []string{"2026-10-14_100000", "2026-10-14_080000", "2026-10-13_090000", "2026-10-13_070000", "2026-10-12_090000"},
+			want:   []string{"2026-10-13_090000", "2026-10-14_100000"},
+		},
+		{
+			name:   "newest run of each week",
+			config: Config{BackupKeepWeekly: 2},
+			runs:   []string{"2026-10-13_080000", "2026-10-12_080000", "2026-10-09_080000", "2026-10-05_080000", "2026-10-04_080000"},
+			want:   []string{"2026-10-09_080000", "2026-10-13_080000"},
+		},
+		{
+			name:   "policies together",
+			config: Config{BackupKeep: 1, BackupKeepDaily: 2, BackupKeepWeekly: 2},
+			runs:   []string{"2026-10-14_100000", "2026-10-14_080000", "2026-10-13_080000", "2026-10-08_080000", "2026-10-07_080000"},
+			want:   []string{"2026-10-08_080000", "2026-10-13_080000", "2026-10-14_100000"},
+		},
+		{
+			name:   "snapshots of the same second",
+			config: Config{BackupKeepDaily: 1},
+			runs:   []string{"2026-10-14_100000", "2026-10-14_100000-2"},
+			want:   []string{"2026-10-14_100000-2"},
+		},
+		{
+			name:   "names that are not runs",
+			config: Config{BackupKeepDaily: 7},
+			runs:   []string{"notes", "2026-10-14_100000"},
+			want:   []string{"2026-10-14_100000"},
+		},
+		{
+			name:   "days without runs",
+			config: Config{BackupKeepDaily: 3},
+			runs:   []string{"2026-09-30_080000", "2026-09-29_200000", "2026-09-29_080000", "2026-09-25_080000", "2026-09-24_080000"},
+			want:   []string{"2026-09-25_080000", "2026-09-29_200000", "2026-09-30_080000"},
+		},
+		{
+			name:   "two weeks off with backup_keep_daily 7",
+			config: Config{BackupKeepDaily: 7},
+			runs:   []string{"2026-09-30_080000", "2026-09-29_080000"},
+			want:   []string{"2026-09-29_080000", "2026-09-30_080000"},
+		},
+		{
+			name:   "weeks without runs",
+			config: Config{BackupKeepWeekly: 2},
+			runs:   []string{"2026-08-05_080000", "2026-08-04_080000", "2026-07-15_080000", "2026-07-01_080000"},
+			want:   []string{"2026-07-15_080000", "2026-08-05_080000"},
+		},
+		{
+			name:   "no policy",
+			config: Config{},
+			runs:   []string{"2026-10-14_100000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for run := range retainedRuns(tt.runs, tt.config, now) {
+				got = append(got, run)
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Errorf("retainedRuns(%q) = %q, want %q", tt.runs, got, tt.want)
+			}
+		})
+	}
+}
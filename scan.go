@@ -0,0 +1,240 @@
+package main
+
+import (
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Values accepted by the sort_order option
+const (
+	sortLexical = "lexical" // by path
+	sortMtime   = "mtime"   // oldest first
+	sortSize    = "size"    // smallest first
+)
+
+// scanResult is what the pre-scan found in the source
+type scanResult struct {
+	Paths   []string             // every name to hand to the workers, in walk order
+	Order   []int32              // indexes of Paths in the order of sort_order, if set
+	Folders []bool               // of Paths, whether a directory
+	Files   int64                // files that need to be copied
+	Bytes   int64                // bytes that need to be copied
+	Growth  int64                // bytes the destination grows by, net of the files replaced
+	Created int64                // files and directories that do not exist at the destination yet
+	TooLong []string             // names whose destination path goes over the limits
+	Dirs    map[string]*dirTotal // what needs to be copied per top-level directory
+
+	seen     map[string]bool // names in Paths, when they are not in walk order
+	excluded map[string]bool // excluded directories, whose contents were not walked
+}
+
+// dirTotal is what needs to be copied from a top-level directory
+type dirTotal struct {
+	Files int64
+	Bytes int64
+}
+
+// topLevel is the top-level directory of name, or "." for the files at the
+// root of the source
+func topLevel(name string) string {
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return "."
+}
+
+// scanSource walks the source before copying, so the total amount of work
+// is known upfront
+func scanSource(src fs.FS, dst DestFS, config Config, filter *filter) (scanResult, error) {
+	result := scanResult{Dirs: make(map[string]*dirTotal), excluded: make(map[string]bool)}
+
+	// Hashing is left to the workers: files that differ only in their
+	// modification time are counted, even if checksums will find them equal
+	quick := config
+	quick.Checksum = false
+	limit := newPathLimit(dst, config)
+	var keys []int64 // of Paths, when sorting by mtime or size
+
+	err := fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		result.Paths = append(result.Paths, name)
+
+		info, err := fs.Stat(src, name)
+		if err != nil {
+			return err
+		}
+		result.Folders = append(result.Folders, info.IsDir())
+		switch {
+		case config.SortOrder != sortMtime && config.SortOrder != sortSize:
+		case info.IsDir():
+			keys = append(keys, math.MinInt64) // created before their files
+		case config.SortOrder == sortMtime:
+			keys = append(keys, info.ModTime().UnixNano())
+		default:
+			keys = append(keys, info.Size())
+		}
+
+		if _, excluded := filter.exclude(name, info); excluded {
+			if d.IsDir() {
+				result.excluded[name] = true
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if _, long := limit.tooLong(destName(dst, name)); long && name != "." {
+			result.TooLong = append(result.TooLong, name)
+			return nil
+		}
+
+		if d.IsDir() {
+			if _, err := dst.Stat(name); err != nil {
+				result.Created++
+			}
+			return nil
+		}
+		if isSpecial(info) {
+			return nil // nothing to copy, whatever special_files does
+		}
+
+		// Comparison errors are reported by the worker
+		if needed, _, err := shouldCopy(src, dst, name, quick, nil); err == nil && needed {
+			result.Files++
+			result.Bytes += info.Size()
+			result.Growth += info.Size()
+			dir := result.Dirs[topLevel(name)]
+			if dir == nil {
+				dir = &dirTotal{}
+				result.Dirs[topLevel(name)] = dir
+			}
+			dir.Files++
+			dir.Bytes += info.Size()
+			if destInfo, err := dst.Stat(name); err == nil {
+				result.Growth -= destInfo.Size()
+			} else {
+				result.Created++
+			}
+		}
+		return nil
+	})
+	result.index()
+	if err == nil {
+		result.sort(config.SortOrder, keys)
+	}
+	return result, err
+}
+
+// sort orders the paths by sort_order, keeping the walk order between the
+// paths that tie, so that the same tree is always sent the same way
+func (r *scanResult) sort(order string, keys []int64) {
+	if order == "" {
+		return
+	}
+	r.Order = make([]int32, len(r.Paths))
+	for i := range r.Order {
+		r.Order[i] = int32(i)
+	}
+	sort.SliceStable(r.Order, func(a, b int) bool {
+		i, j := r.Order[a], r.Order[b]
+		if order == sortLexical {
+			return r.Paths[i] < r.Paths[j]
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// at is the index in Paths of the name planned i-th
+func (r *scanResult) at(i int) int {
+	if r.Order != nil {
+		return int(r.Order[i])
+	}
+	return i
+}
+
+// nth is the name planned i-th
+func (r *scanResult) nth(i int) string {
+	return r.Paths[r.at(i)]
+}
+
+// task is the task for the name planned i-th: a directory to create or a
+// file to check. Job files saved without Folders have the source read again.
+func (r *scanResult) task(src fs.FS, i int) task {
+	j := r.at(i)
+	t := task{kind: taskCopy, name: r.Paths[j]}
+	folder := false
+	if len(r.Folders) == len(r.Paths) {
+		folder = r.Folders[j]
+	} else if info, err := fs.Stat(src, t.name); err == nil {
+		folder = info.IsDir()
+	}
+	if folder {
+		t.kind = taskMkdir
+	}
+	return t
+}
+
+// index lets inSource look the names up in Paths. Sorted by fs.ReadDir,
+// they are searched in place; only a file system listing folders in
+// another order costs a set of every name.
+func (r *scanResult) index() {
+	if sort.SliceIsSorted(r.Paths, func(i, j int) bool { return walkBefore(r.Paths[i], r.Paths[j]) }) {
+		return
+	}
+	r.seen = make(map[string]bool, len(r.Paths))
+	for _, name := range r.Paths {
+		r.seen[name] = true
+	}
+}
+
+// walkBefore reports whether fs.WalkDir visits a before b: the root first,
+// each folder before its contents, and the entries of a folder by name
+func walkBefore(a, b string) bool {
+	switch {
+	case a == b || b == ".":
+		return false
+	case a == ".":
+		return true
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			switch {
+			case a[i] == '/':
+				return true
+			case b[i] == '/':
+				return false
+			}
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// scanned reports whether name is in Paths
+func (r *scanResult) scanned(name string) bool {
+	if r.seen != nil {
+		return r.seen[name]
+	}
+	i := sort.Search(len(r.Paths), func(i int) bool { return !walkBefore(r.Paths[i], name) })
+	return i < len(r.Paths) && r.Paths[i] == name
+}
+
+// inSource reports whether name exists in the scanned source, including
+// below the excluded directories that were not walked
+func (r *scanResult) inSource(name string) bool {
+	if r.scanned(name) {
+		return true
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if r.excluded[dir] {
+			return true
+		}
+		if dir == "." {
+			return false
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWalkBefore(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{".", "a", true},
+		{"a", ".", false},
+		{".", ".", false},
+		{"a", "a", false},
+		{"a", "a/x", true},
+		{"a/x", "a", false},
+		{"a/x", "a-b", true}, // the contents of a come before its next sibling
+		{"a-b", "a/x", false},
+		{"a/z", "b", true},
+		{"a/x", "a/y", true},
+		{"ab", "a/x", false},
+	}
+	for _, tt := range tests {
+		if got := walkBefore(tt.a, tt.b); got != tt.want {
+			t.Errorf("walkBefore(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestScanSortOrder(t *testing.T) {
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	src := fstest.MapFS{
+		"a/x": {Data: make([]byte, 30), ModTime: day.Add(3 * time.Hour)},
+		"a/y": {Data: make([]byte, 10), ModTime: day.Add(1 * time.Hour)},
+		"a-b": {Data: make([]byte, 20), ModTime: day.Add(1 * time.Hour)},
+		"c":   {Data: make([]byte, 10), ModTime: day.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"", []string{".", "a", "a/x", "a/y", "a-b", "c"}},
+		{sortLexical, []string{".", "a", "a-b", "a/x", "a/y", "c"}},
+		// Folders first, ties in walk order
+		{sortMtime, []string{".", "a", "a/y", "a-b", "c", "a/x"}},
+		{sortSize, []string{".", "a", "a/y", "c", "a-b", "a/x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			config := Config{Destination: t.TempDir(), SortOrder: tt.order}
+			filter, err := newFilter(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scan, err := scanSource(src, openDestination(config), config, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i := range scan.Paths {
+				got = append(got, scan.nth(i))
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("sort_order %q sends %q, want %q", tt.order, got, tt.want)
+			}
+			for _, name := range tt.want {
+				if !scan.inSource(name) {
+					t.Errorf("inSource(%q) = false", name)
+				}
+			}
+			if scan.inSource("a/z") {
+				t.Errorf("inSource(%q) = true", "a/z")
+			}
+
+			// Job files saved before Folders read the kinds from the source
+			folders := scan.Folders
+			for _, saved := range [][]bool{folders, nil} {
+				scan.Folders = saved
+				for i := range scan.Paths {
+					task := scan.task(src, i)
+					if want := task.name == "." || task.name == "a"; (task.kind == taskMkdir) != want {
+						t.Errorf("task %q has kind %d with Folders %v", task.name, task.kind, saved)
+					}
+				}
+			}
+		})
+	}
+}
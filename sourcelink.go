@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Values accepted by the source_symlink option
+const (
+	sourceLinkResolve = "resolve" // sync the folder the link leads to (default)
+	sourceLinkRefuse  = "refuse"  // stop when source is a link
+)
+
+// resolveSource is source with its trailing separator, once the links of
+// its path are resolved when it is a link itself, so that every path of the
+// run is computed from the folder really walked. A missing source is left
+// for the sync to report.
+func resolveSource(source, policy string) (string, error) {
+	clean := filepath.Clean(source)
+	info, err := os.Lstat(clean)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.Mode()&fs.ModeSymlink == 0 {
+		return source, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if policy == sourceLinkRefuse {
+		return "", fmt.Errorf("source %s is a symbolic link and source_symlink is refuse", clean)
+	}
+	target, err := filepath.EvalSymlinks(clean)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve source %s: %v", clean, err)
+	}
+	if strings.HasSuffix(source, "/") || strings.HasSuffix(source, string(filepath.Separator)) {
+		target += string(filepath.Separator)
+	}
+	return target, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Values accepted by the special_files option, for the named pipes,
+// sockets and devices of the source, which have no content to copy
+const (
+	specialSkip     = "skip"     // leave them out, reported with -verbose
+	specialWarn     = "warn"     // leave them out with a warning (default)
+	specialRecreate = "recreate" // make the same node at the destination
+	specialFail     = "fail"     // count each as an error
+)
+
+// specialMode is what marks a special file
+const specialMode = fs.ModeNamedPipe | fs.ModeSocket | fs.ModeDevice | fs.ModeCharDevice
+
+var errSpecialUnsupported = errors.New("special files cannot be recreated on this platform")
+
+// isSpecial reports whether info is a named pipe, a socket or a device,
+// which opening would block on or read forever
+func isSpecial(info fs.FileInfo) bool {
+	return info.Mode()&specialMode != 0
+}
+
+// specialKind names the kind of the special file info
+func specialKind(info fs.FileInfo) string {
+	switch mode := info.Mode(); {
+	case mode&fs.ModeNamedPipe != 0:
+		return tr("named pipe")
+	case mode&fs.ModeSocket != 0:
+		return tr("socket")
+	default:
+		return tr("device")
+	}
+}
+
+// specialFile applies special_files to the special file name, instead of
+// opening it, returning what checkEntry returns
+func (s *syncer) specialFile(id int, name string, info fs.FileInfo) (fs.FileInfo, bool, bool) {
+	path := displayPath(s.src, name)
+	reason := fmt.Sprintf(tr("%s, not a regular file"), specialKind(info))
+	policy := s.config.SpecialFiles
+	if policy == specialRecreate && info.Mode()&fs.ModeSocket != 0 {
+		// A socket only exists while a program listens on it
+		policy, reason = specialWarn, tr("socket, which cannot be recreated")
+	}
+	switch policy {
+	case specialSkip:
+		skippedf("Worker %d: Skipping %s: %s\n", id, path, reason)
+	case specialWarn:
+		errorf("Worker %d: Warning: skipping %s: %s\n", id, path, reason)
+	case specialFail:
+		errorf("Worker %d: Error copying %s: %s\n", id, path, reason)
+		s.stats.addError()
+		s.report.add(actionFailed, name, 0, 0, reason)
+		return info, false, false
+	case specialRecreate:
+		if existing, err := s.dst.Stat(name); err == nil && existing.Mode() == info.Mode() {
+			reason = tr("already at the destination")
+			skippedf("Worker %d: Skipping %s: %s\n", id, path, reason)
+			s.stats.addSkipped()
+			s.report.add(actionSkipped, name, 0, 0, reason)
+			return info, false, true
+		}
+		if err := s.recreateSpecial(name, info); err != nil {
+			errorf("Worker %d: Error recreating %s: %v\n", id, displayPath(s.dst, name), err)
+			s.stats.addError()
+			s.report.add(actionFailed, name, 0, 0, err.Error())
+			return info, false, false
+		}
+		copiedf("Worker %d: Recreated %s %s\n", id, specialKind(info), displayPath(s.dst, name))
+		s.report.add(actionCopied, name, 0, 0, "")
+		return info, false, true
+	}
+	s.stats.addSkipped()
+	s.report.add(actionSkipped, name, 0, 0, reason)
+	return info, false, true
+}
+
+// recreateSpecial makes the node of info at the destination, replacing what
+// is there. Devices take a root account, and only a local destination has
+// nodes at all.
+func (s *syncer) recreateSpecial(name string, info fs.FileInfo) error {
+	local, ok := s.dst.(localFS)
+	if !ok {
+		return fmt.Errorf("the destination cannot hold special files")
+	}
+	if err := s.dst.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := makeSpecial(local.localPath(name), info); err != nil {
+		return err
+	}
+	return preserveFileTimes(info, s.dst, name, s.config.PreserveTimes)
+}
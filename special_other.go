@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "io/fs"
+
+func makeSpecial(path string, info fs.FileInfo) error {
+	return errSpecialUnsupported
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// makeSpecial makes at path a named pipe or device like info
+func makeSpecial(path string, info fs.FileInfo) error {
+	perm := uint32(info.Mode().Perm())
+	if info.Mode()&fs.ModeNamedPipe != 0 {
+		return syscall.Mkfifo(path, perm)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errSpecialUnsupported
+	}
+	kind := uint32(syscall.S_IFBLK)
+	if info.Mode()&fs.ModeCharDevice != 0 {
+		kind = syscall.S_IFCHR
+	}
+	return syscall.Mknod(path, kind|perm, int(stat.Rdev))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tombstoneRetention is how long propagated tombstones are kept to
+// recognize resurrected files
+const tombstoneRetention = 90 * 24 * time.Hour
+
+// State is the state DB kept between runs in state_file
+type State struct {
+	mu sync.Mutex
+
+	Files      map[string]FileState `json:"files"`              // synced files by name
+	Tombstones map[string]Tombstone `json:"tombstones"`         // files deleted at the source
+	Failures   map[string]Failure   `json:"failures,omitempty"` // files that could not be copied
+	Runs       []RunRecord          `json:"runs,omitempty"`     // the last runs, oldest first
+	Versions   map[string]string    `json:"versions,omitempty"` // latest version of the files, with append_only
+	Listings   *ListingCache        `json:"listings,omitempty"` // of the destination, with listing_cache_ttl
+}
+
+// FileState is a file as it was last synced
+type FileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Synced  time.Time `json:"synced"`
+	Hash    string    `json:"hash,omitempty"` // "algorithm:hex", when known
+	Audited time.Time `json:"audited,omitempty"`
+}
+
+// Tombstone records a file deleted at the source, so the deletion reaches
+// the destination even when it was unreachable at the time
+type Tombstone struct {
+	FileState
+	Deleted    time.Time `json:"deleted"`    // when the deletion was observed
+	Propagated bool      `json:"propagated"` // whether the destination copy is gone
+}
+
+// loadState reads the state DB, which is empty on the first run
+func loadState(path string) (*State, error) {
+	state := &State{Files: make(map[string]FileState), Tombstones: make(map[string]Tombstone), Failures: make(map[string]Failure), Versions: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]FileState)
+	}
+	if state.Tombstones == nil {
+		state.Tombstones = make(map[string]Tombstone)
+	}
+	if state.Failures == nil {
+		state.Failures = make(map[string]Failure)
+	}
+	if state.Versions == nil {
+		state.Versions = make(map[string]string)
+	}
+	return state, nil
+}
+
+// save writes the state DB
+func (s *State) save(path string) error {
+	s.mu.Lock()
+	data, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes a file through a temporary file renamed over it,
+// so that readers never see it half-written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordSynced records that name is in sync with the source file described
+// by info, with its hash if known. The hash recorded before is kept as long
+// as the file is unchanged.
+func (s *State) recordSynced(name string, info fs.FileInfo, hash string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.Files[name]
+	if hash == "" && ok && previous.matches(info, 0) {
+		hash = previous.Hash
+	}
+	s.Files[name] = FileState{Size: info.Size(), ModTime: info.ModTime(), Synced: time.Now(), Hash: hash}
+	delete(s.Failures, name)
+}
+
+// storedHash returns the recorded hash of name with the algorithm, provided
+// that the file described by info still has the recorded size and time
+func (s *State) storedHash(name string, info fs.FileInfo, window time.Duration, algorithm string) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	file, ok := s.Files[name]
+	s.mu.Unlock()
+	if !ok || !file.matches(info, window) {
+		return nil, false
+	}
+	return parseHash(file.Hash, algorithm)
+}
+
+// matches reports whether the file described by info has the recorded size
+// and modification time, within window for the times of the destination
+func (f FileState) matches(info fs.FileInfo, window time.Duration) bool {
+	d := f.ModTime.Sub(info.ModTime())
+	return f.Size == info.Size() && d <= window && d >= -window
+}
+
+// forget drops name from the synced files, without a tombstone
+func (s *State) forget(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Files, name)
+}
+
+// observeSource turns the synced files missing from the source into
+// tombstones, clears the tombstones of files that reappeared, and forgets
+// the failures of files that are gone
+func (s *State) observeSource(present func(name string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for name, file := range s.Files {
+		if !present(name) {
+			s.Tombstones[name] = Tombstone{FileState: file, Deleted: now}
+			delete(s.Files, name)
+		}
+	}
+
+	for name, tombstone := range s.Tombstones {
+		switch {
+		case present(name):
+			debugf("%s reappeared at the source after being deleted on %s\n", name, tombstone.Deleted.Format(time.RFC3339))
+			delete(s.Tombstones, name)
+		case tombstone.Propagated && now.Sub(tombstone.Deleted) > tombstoneRetention:
+			delete(s.Tombstones, name)
+		}
+	}
+
+	for name := range s.Failures {
+		if !present(name) {
+			delete(s.Failures, name)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts what happened during a sync run
+type Stats struct {
+	Copied  atomic.Int64
+	Skipped atomic.Int64
+	Errors  atomic.Int64
+	Bytes   atomic.Int64
+	Moved   atomic.Int64
+	Renamed atomic.Int64
+	Deleted atomic.Int64
+	Start   time.Time
+
+	sizes  *histogram
+	speeds *histogram
+	top    *topFiles
+}
+
+func newStats() *Stats {
+	return &Stats{
+		Start:  time.Now(),
+		sizes:  newHistogram(sizeBounds),
+		speeds: newHistogram(speedBounds),
+	}
+}
+
+func (s *Stats) addCopied(path string, bytes int64, duration time.Duration) {
+	s.Copied.Add(1)
+	s.Bytes.Add(bytes)
+	s.addTransfer(bytes, duration)
+	s.top.add(copiedFile{path, bytes, duration})
+}
+
+func (s *Stats) addSkipped() {
+	s.Skipped.Add(1)
+}
+
+func (s *Stats) addError() {
+	s.Errors.Add(1)
+}
+
+// Summary returns a one-line description of the run
+func (s *Stats) Summary() string {
+	elapsed := time.Since(s.Start).Round(time.Second)
+	summary := fmt.Sprintf(tr("Copied %d files (%s), skipped %d, %d errors in %s"),
+		s.Copied.Load(), formatBytes(s.Bytes.Load()), s.Skipped.Load(), s.Errors.Load(), elapsed)
+	if deleted := s.Deleted.Load(); deleted > 0 {
+		summary += fmt.Sprintf(tr(", %d deleted"), deleted)
+	}
+	if renamed := s.Renamed.Load(); renamed > 0 {
+		summary += fmt.Sprintf(tr(", %d renamed"), renamed)
+	}
+	if moved := s.Moved.Load(); moved > 0 {
+		summary += fmt.Sprintf(tr(", %d removed from the source"), moved)
+	}
+	return summary
+}
+
+// Line returns the last line of the output, for scripts to grep: unlike
+// Summary, its format does not depend on the language or on what happened,
+// as in "gosync: 1234 copied, 56 skipped, 2 errors, 48.2 GiB in 22m11s
+// (37.1 MiB/s)"
+func (s *Stats) Line() string {
+	elapsed := time.Since(s.Start)
+	bytes := s.Bytes.Load()
+	return fmt.Sprintf("gosync: %d copied, %d skipped, %d errors, %s in %s (%s/s)",
+		s.Copied.Load(), s.Skipped.Load(), s.Errors.Load(), formatBytes(bytes), elapsed.Round(time.Second),
+		formatBytes(int64(float64(bytes)/max(elapsed.Seconds(), 1e-3))))
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
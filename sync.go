@@ -1,85 +1,585 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/schollz/progressbar/v3"
 )
 
+// defaultBufferSize is the copy buffer size when buffer_size is not set
+const defaultBufferSize = 32 * 1024
+
+// niceIOPause is the pause between copies with nice_io, to leave the disks
+// to interactive programs for a moment
+const niceIOPause = 20 * time.Millisecond
+
+// defaultQueueDepth is how many batches wait for the checkers, and for the
+// workers, when queue_depth is not set
+const defaultQueueDepth = 100
+
+// smallFileSize is the largest file the checkers batch with batch_files
+const smallFileSize = 64 << 10
+
+var progressFormat = flag.String("progress-format", progressFormatBar, "progress output: bar or json (newline-delimited events on stdout)")
+
 // Config struct for source, destination paths, and log file path
 type Config struct {
-	Source      string `json:"source"`
-	Destination string `json:"destination"`
-	LogFile     string `json:"logfile"`
-	Worker     int `json:"worker"`
-	SkipExtensions []string `json:"skip_extensions"`
+	Source                 string   `json:"source"`
+	SourceSymlink          string   `json:"source_symlink"`
+	Destination            string   `json:"destination"`
+	RclonePath             string   `json:"rclone_path"`
+	ListWorkers            int      `json:"list_workers"`
+	ListingCacheTTL        string   `json:"listing_cache_ttl"`
+	DestinationFormat      string   `json:"destination_format"`
+	Compression            string   `json:"compression"`
+	CompressionLevel       int      `json:"compression_level"`
+	CompressSkipExtensions []string `json:"compress_skip_extensions"`
+	TargetFS               string   `json:"target_fs"`
+	NamePolicy             string   `json:"name_policy"`
+	MaxPath                int      `json:"max_path"`
+	LongPaths              string   `json:"long_paths"`
+	TrailingSlash          bool     `json:"trailing_slash"`
+	LogFile                string   `json:"logfile"`
+	LogFormat              string   `json:"log_format"`
+	ReportFile             string   `json:"report_file"`
+	HTMLReport             string   `json:"html_report"`
+	ManifestFile           string   `json:"manifest_file"`
+	ManifestKey            string   `json:"manifest_key"`
+	TopFiles               int      `json:"top_files"`
+	Language               string   `json:"language"`
+	Worker                 int      `json:"worker"`
+	SkipExtensions         []string `json:"skip_extensions"`
+	SkipOwners             []string `json:"skip_owners"`
+	SkipGroups             []string `json:"skip_groups"`
+	ExcludeCmd             []string `json:"exclude_cmd"`
+	IncludeFrom            []string `json:"include_from"`
+	ExcludeFrom            []string `json:"exclude_from"`
+	CopyADS                bool     `json:"copy_ads"`
+	PreserveTimes          string   `json:"preserve_times"`
+	MetadataSidecar        bool     `json:"metadata_sidecar"`
+	FileMode               string   `json:"file_mode"`
+	DirMode                string   `json:"dir_mode"`
+	Umask                  string   `json:"umask"`
+	BufferSize             int      `json:"buffer_size"`
+	SpaceCheck             string   `json:"space_check"`
+	Move                   bool     `json:"move"`
+	StateFile              string   `json:"state_file"`
+	PropagateDeletes       bool     `json:"propagate_deletes"`
+	AppendOnly             bool     `json:"append_only"`
+	DetectRenames          bool     `json:"detect_renames"`
+	DeleteExcluded         bool     `json:"delete_excluded"`
+	AnomalyCheck           string   `json:"anomaly_check"`
+	AnomalyFactor          float64  `json:"anomaly_factor"`
+	QuarantineAfter        int      `json:"quarantine_after"`
+	IgnoreExisting         bool     `json:"ignore_existing"`
+	Update                 bool     `json:"update"`
+	PruneEmptyDirs         bool     `json:"prune_empty_dirs"`
+	JobFile                string   `json:"job_file"`
+	JournalFile            string   `json:"journal_file"`
+	BackupDir              string   `json:"backup_dir"`
+	BackupKeep             int      `json:"backup_keep"`
+	BackupKeepDaily        int      `json:"backup_keep_daily"`
+	BackupKeepWeekly       int      `json:"backup_keep_weekly"`
+	ErrorThreshold         int      `json:"error_threshold"`
+	ErrorBackoff           string   `json:"error_backoff"`
+	OnCopy                 []string `json:"on_copy"`
+	OnCopyInput            string   `json:"on_copy_input"`
+	Resume                 bool     `json:"resume"`
+	Confirm                bool     `json:"confirm"`
+	BwLimit                string   `json:"bwlimit"`
+	MaxTransfer            string   `json:"max_transfer"`
+	MaxFiles               int      `json:"max_files"`
+	MaxDuration            string   `json:"max_duration"`
+	NiceIO                 bool     `json:"nice_io"`
+	Fsync                  bool     `json:"fsync"`
+	DirectIO               bool     `json:"direct_io"`
+	Preallocate            bool     `json:"preallocate"`
+	Checksum               bool     `json:"checksum"`
+	Hash                   string   `json:"hash"`
+	HashWorkers            int      `json:"hash_workers"`
+	QueueDepth             int      `json:"queue_depth"`
+	BatchFiles             int      `json:"batch_files"`
+	SortOrder              string   `json:"sort_order"`
+	SpecialFiles           string   `json:"special_files"`
+	AuditPercent           int      `json:"audit_percent"`
+	MemoryLimit            string   `json:"memory_limit"`
+	TraceEndpoint          string   `json:"trace_endpoint"`
+	Proxy                  string   `json:"proxy"`
+}
+
+// applyFlags sets the options given on the command line over those of
+// config
+func applyFlags(config *Config) {
+	if *move {
+		config.Move = true
+	}
+	if *update {
+		config.Update = true
+	}
+	if *resume {
+		config.Resume = true
+	}
+	if *maxDuration > 0 {
+		config.MaxDuration = maxDuration.String()
+	}
+	config.IncludeFrom = append(config.IncludeFrom, includeFrom...)
+	config.ExcludeFrom = append(config.ExcludeFrom, excludeFrom...)
 }
 
-// ReadConfig reads the config from a JSON file
+// ReadConfig reads the config from a JSON file, with the options set in
+// the GOSYNC_ environment variables and on the command line over it, and
+// checks them all alike. The file may be missing when the environment
+// configures the sync.
 func ReadConfig(filename string) (Config, error) {
 	var config Config
 	file, err := os.Open(filename)
-	if err != nil {
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && envConfigured():
+	case err != nil:
+		return config, err
+	default:
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		if err := decoder.Decode(&config); err != nil {
+			return config, err
+		}
+	}
+	if err := applyEnv(&config); err != nil {
+		return config, err
+	}
+	applyFlags(&config)
+
+	switch config.DestinationFormat {
+	case "":
+		config.DestinationFormat = formatMirror
+	case formatMirror:
+	case formatChunks:
+		// What only makes sense for a copy of the tree
+		switch {
+		case config.PropagateDeletes:
+			return config, fmt.Errorf("destination_format chunks does not support propagate_deletes: snapshots keep the deleted files")
+		case config.BackupDir != "":
+			return config, fmt.Errorf("destination_format chunks does not support backup_dir: snapshots keep the replaced files")
+		case config.TargetFS != "":
+			return config, fmt.Errorf("destination_format chunks does not support target_fs")
+		case strings.HasPrefix(config.Destination, rclonePrefix):
+			return config, fmt.Errorf("destination_format chunks does not support rclone destinations")
+		case config.Move:
+			return config, fmt.Errorf("destination_format chunks does not support move")
+		}
+		// What the snapshots do not implement
+		switch {
+		case config.Update:
+			return config, fmt.Errorf("destination_format chunks does not support update")
+		case config.Resume:
+			return config, fmt.Errorf("destination_format chunks does not support resume: an interrupted snapshot is taken again")
+		case config.Fsync:
+			return config, fmt.Errorf("destination_format chunks does not support fsync")
+		case config.BwLimit != "":
+			return config, fmt.Errorf("destination_format chunks does not support bwlimit")
+		case config.NiceIO:
+			return config, fmt.Errorf("destination_format chunks does not support nice_io")
+		case config.ReportFile != "" || config.HTMLReport != "":
+			return config, fmt.Errorf("destination_format chunks does not support report_file and html_report: the snapshot lists the files")
+		case config.JournalFile != "":
+			return config, fmt.Errorf("destination_format chunks does not support journal_file: snapshots are written once complete")
+		}
+	default:
+		return config, fmt.Errorf("invalid destination_format %q: must be mirror or chunks", config.DestinationFormat)
+	}
+
+	switch config.Compression {
+	case "":
+		config.Compression = compressionNone
+	case compressionNone:
+	case compressionGzip:
+		if config.DestinationFormat != formatChunks {
+			return config, fmt.Errorf("compression requires destination_format chunks")
+		}
+	default:
+		return config, fmt.Errorf("invalid compression %q: must be none or gzip", config.Compression)
+	}
+	switch {
+	case config.CompressionLevel == 0:
+		config.CompressionLevel = gzip.DefaultCompression
+	case config.CompressionLevel < gzip.BestSpeed || config.CompressionLevel > gzip.BestCompression:
+		return config, fmt.Errorf("invalid compression_level %d: must be 1 to 9", config.CompressionLevel)
+	}
+	if config.CompressSkipExtensions == nil {
+		config.CompressSkipExtensions = defaultCompressSkip
+	}
+
+	switch {
+	case config.ManifestFile != "" && config.DestinationFormat == formatChunks:
+		return config, fmt.Errorf("manifest_file requires destination_format mirror: snapshots list the hashes already")
+	case config.ManifestKey != "" && config.ManifestFile == "":
+		return config, fmt.Errorf("manifest_key requires a manifest_file")
+	case config.ManifestKey != "":
+		if _, err := readSigningKey(config.ManifestKey); err != nil {
+			return config, fmt.Errorf("invalid manifest_key: %v", err)
+		}
+	}
+
+	switch config.PreserveTimes {
+	case "":
+		config.PreserveTimes = preserveMtime
+	case preserveNone, preserveMtime, preserveAtime, preserveAll:
+	default:
+		return config, fmt.Errorf("invalid preserve_times %q", config.PreserveTimes)
+	}
+
+	if err := validatePermissions(config); err != nil {
+		return config, err
+	}
+
+	switch {
+	case config.BufferSize == 0:
+		config.BufferSize = defaultBufferSize
+	case config.BufferSize < 0:
+		return config, fmt.Errorf("invalid buffer_size %d", config.BufferSize)
+	}
+
+	switch {
+	case config.ListWorkers == 0:
+		config.ListWorkers = defaultListWorkers
+	case config.ListWorkers < 0:
+		return config, fmt.Errorf("invalid list_workers %d", config.ListWorkers)
+	}
+
+	switch {
+	case config.TopFiles == 0:
+		config.TopFiles = defaultTopFiles
+	case config.TopFiles < 0:
+		return config, fmt.Errorf("invalid top_files %d", config.TopFiles)
+	}
+
+	switch config.SpaceCheck {
+	case "":
+		config.SpaceCheck = spaceCheckAbort
+	case spaceCheckAbort, spaceCheckWarn, spaceCheckOff:
+	default:
+		return config, fmt.Errorf("invalid space_check %q", config.SpaceCheck)
+	}
+
+	switch config.AnomalyCheck {
+	case "":
+		config.AnomalyCheck = anomalyOff
+	case anomalyOff:
+	case anomalyWarn, anomalyAbort:
+		if config.StateFile == "" {
+			return config, fmt.Errorf("anomaly_check requires a state_file")
+		}
+	default:
+		return config, fmt.Errorf("invalid anomaly_check %q", config.AnomalyCheck)
+	}
+	switch {
+	case config.AnomalyFactor == 0:
+		config.AnomalyFactor = defaultAnomalyFactor
+	case config.AnomalyFactor < 1:
+		return config, fmt.Errorf("invalid anomaly_factor %g: must be at least 1", config.AnomalyFactor)
+	}
+
+	if config.PropagateDeletes && config.StateFile == "" {
+		return config, fmt.Errorf("propagate_deletes requires a state_file")
+	}
+	switch {
+	case config.QuarantineAfter < 0:
+		return config, fmt.Errorf("invalid quarantine_after %d", config.QuarantineAfter)
+	case config.QuarantineAfter > 0 && config.StateFile == "":
+		return config, fmt.Errorf("quarantine_after requires a state_file")
+	}
+	if config.ListingCacheTTL != "" {
+		switch ttl, err := time.ParseDuration(config.ListingCacheTTL); {
+		case err != nil || ttl <= 0:
+			return config, fmt.Errorf("invalid listing_cache_ttl %q: must be a positive duration such as 30m", config.ListingCacheTTL)
+		case config.StateFile == "":
+			return config, fmt.Errorf("listing_cache_ttl requires a state_file")
+		case !strings.HasPrefix(config.Destination, rclonePrefix):
+			return config, fmt.Errorf("listing_cache_ttl requires an rclone: destination")
+		}
+	}
+	if config.AppendOnly {
+		switch {
+		case config.StateFile == "":
+			return config, fmt.Errorf("append_only requires a state_file, to find the latest version of each file")
+		case config.DestinationFormat == formatChunks:
+			return config, fmt.Errorf("append_only requires destination_format mirror: chunk stores only add files already")
+		case config.PropagateDeletes, config.BackupDir != "", config.PruneEmptyDirs, config.MetadataSidecar:
+			return config, fmt.Errorf("append_only does not support propagate_deletes, backup_dir, prune_empty_dirs or metadata_sidecar, which change or remove destination files")
+		}
+	}
+	if config.DetectRenames && !config.PropagateDeletes {
+		return config, fmt.Errorf("detect_renames requires propagate_deletes")
+	}
+	// A renamed copy keeps its old time, which only checksum then takes as equal
+	if config.DetectRenames && config.PreserveTimes == preserveNone && !config.Checksum {
+		return config, fmt.Errorf("detect_renames requires checksum with preserve_times none, or the renamed files are copied again")
+	}
+	if config.DeleteExcluded && !config.PropagateDeletes {
+		return config, fmt.Errorf("delete_excluded requires propagate_deletes")
+	}
+
+	switch {
+	case config.QueueDepth == 0:
+		config.QueueDepth = defaultQueueDepth
+	case config.QueueDepth < 0:
+		return config, fmt.Errorf("invalid queue_depth %d", config.QueueDepth)
+	}
+
+	switch {
+	case config.BatchFiles == 0:
+		config.BatchFiles = 1
+	case config.BatchFiles < 0:
+		return config, fmt.Errorf("invalid batch_files %d", config.BatchFiles)
+	}
+
+	switch {
+	case config.HashWorkers == 0:
+		config.HashWorkers = config.Worker
+	case config.HashWorkers < 0:
+		return config, fmt.Errorf("invalid hash_workers %d", config.HashWorkers)
+	}
+
+	switch {
+	case config.AuditPercent == 0:
+		config.AuditPercent = defaultAuditPercent
+	case config.AuditPercent < 0 || config.AuditPercent > 100:
+		return config, fmt.Errorf("invalid audit_percent %d: must be between 1 and 100", config.AuditPercent)
+	}
+
+	if config.ErrorThreshold <= 0 {
+		config.ErrorThreshold = defaultErrorThreshold
+	}
+	if config.ErrorBackoff == "" {
+		config.ErrorBackoff = defaultErrorBackoff.String()
+	}
+	if backoff, err := time.ParseDuration(config.ErrorBackoff); err != nil || backoff <= 0 {
+		return config, fmt.Errorf("invalid error_backoff %q", config.ErrorBackoff)
+	}
+
+	switch config.OnCopyInput {
+	case "":
+		config.OnCopyInput = hookInputArg
+	case hookInputArg, hookInputJSON:
+	default:
+		return config, fmt.Errorf("invalid on_copy_input %q: must be arg or json", config.OnCopyInput)
+	}
+
+	switch config.Hash {
+	case "":
+		config.Hash = hashSHA256
+	case hashSHA256, hashXXH64, hashBLAKE3:
+	default:
+		return config, fmt.Errorf("invalid hash %q: must be sha256, xxh64 or blake3", config.Hash)
+	}
+
+	if config.DirectIO && !directIOSupported {
+		return config, fmt.Errorf("direct_io is not supported on this platform")
+	}
+
+	if config.MemoryLimit != "" {
+		if limit, err := parseSize(config.MemoryLimit); err != nil || limit <= 0 {
+			return config, fmt.Errorf("invalid memory_limit %q: must be a size such as 4G", config.MemoryLimit)
+		}
+	}
+	switch config.SpecialFiles {
+	case "":
+		config.SpecialFiles = specialWarn
+	case specialSkip, specialWarn, specialRecreate, specialFail:
+	default:
+		return config, fmt.Errorf("invalid special_files %q: must be skip, warn, recreate or fail", config.SpecialFiles)
+	}
+	switch config.SortOrder {
+	case "", sortLexical, sortMtime, sortSize:
+	default:
+		return config, fmt.Errorf("invalid sort_order %q: must be lexical, mtime or size", config.SortOrder)
+	}
+	if config.TraceEndpoint != "" && !strings.HasPrefix(config.TraceEndpoint, "http://") && !strings.HasPrefix(config.TraceEndpoint, "https://") {
+		return config, fmt.Errorf("invalid trace_endpoint %q: must be an http:// or https:// URL such as http://localhost:4318", config.TraceEndpoint)
+	}
+	if config.Proxy != "" {
+		if err := checkProxy(config.Proxy); err != nil {
+			return config, fmt.Errorf("invalid proxy %q: %v", config.Proxy, err)
+		}
+	}
+	if config.MaxDuration != "" {
+		if d, err := time.ParseDuration(config.MaxDuration); err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid max_duration %q: must be a positive duration such as 2h30m", config.MaxDuration)
+		}
+	}
+	if config.MaxTransfer != "" {
+		if _, err := parseSize(config.MaxTransfer); err != nil {
+			return config, fmt.Errorf("invalid max_transfer: %v", err)
+		}
+	}
+	switch {
+	case config.MaxFiles < 0:
+		return config, fmt.Errorf("invalid max_files %d", config.MaxFiles)
+	case (config.MaxTransfer != "" || config.MaxFiles > 0 || config.MaxDuration != "") && config.DestinationFormat == formatChunks:
+		return config, fmt.Errorf("max_transfer, max_files and max_duration require destination_format mirror: a snapshot must hold every file")
+	}
+	if config.BwLimit != "" {
+		if _, err := parseBwLimit(config.BwLimit); err != nil {
+			return config, fmt.Errorf("invalid bwlimit: %v", err)
+		}
+	}
+
+	switch config.TargetFS {
+	case "", targetFAT32, targetExFAT, targetNTFS:
+	default:
+		return config, fmt.Errorf("invalid target_fs %q: must be fat32, exfat or ntfs", config.TargetFS)
+	}
+	switch config.NamePolicy {
+	case "":
+		config.NamePolicy = namePolicyReport
+	case namePolicyReport, namePolicyRename:
+	default:
+		return config, fmt.Errorf("invalid name_policy %q: must be report or rename", config.NamePolicy)
+	}
+
+	switch {
+	case config.MaxPath == 0 && config.TargetFS != "":
+		config.MaxPath = windowsMaxPath
+	case config.MaxPath == 0:
+		config.MaxPath = defaultMaxPath
+	case config.MaxPath < 0:
+		return config, fmt.Errorf("invalid max_path %d", config.MaxPath)
+	}
+	switch config.LongPaths {
+	case "":
+		config.LongPaths = longPathsReport
+	case longPathsReport, longPathsShorten:
+	default:
+		return config, fmt.Errorf("invalid long_paths %q: must be report or shorten", config.LongPaths)
+	}
+
+	switch config.LogFormat {
+	case "":
+		config.LogFormat = logFormatText
+	case logFormatText, logFormatJSON:
+	default:
+		return config, fmt.Errorf("invalid log_format %q: must be text or json", config.LogFormat)
+	}
+
+	switch config.Language {
+	case "", languageEnglish, languagePortuguese:
+	default:
+		return config, fmt.Errorf("invalid language %q: must be en or pt-BR", config.Language)
+	}
+
+	switch {
+	case config.BackupKeep < 0:
+		return config, fmt.Errorf("invalid backup_keep %d", config.BackupKeep)
+	case config.BackupKeepDaily < 0:
+		return config, fmt.Errorf("invalid backup_keep_daily %d", config.BackupKeepDaily)
+	case config.BackupKeepWeekly < 0:
+		return config, fmt.Errorf("invalid backup_keep_weekly %d", config.BackupKeepWeekly)
+	}
+
+	if config.JobFile == "" {
+		config.JobFile = defaultJobFile
+	}
+
+	switch config.SourceSymlink {
+	case "":
+		config.SourceSymlink = sourceLinkResolve
+	case sourceLinkResolve, sourceLinkRefuse:
+	default:
+		return config, fmt.Errorf("invalid source_symlink %q: must be resolve or refuse", config.SourceSymlink)
+	}
+
+	// Nested under the name of the link, as given, rather than of its target
+	if config.TrailingSlash {
+		if config.Destination, err = nestDestination(config.Source, config.Destination); err != nil {
+			return config, err
+		}
+	}
+	if config.Source, err = resolveSource(config.Source, config.SourceSymlink); err != nil {
 		return config, err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
-	return config, err
+	return config, nil
+}
+
+// nestDestination is where source goes with trailing_slash: like rsync, a
+// source ending in a separator syncs its contents into destination, and one
+// without syncs the folder itself, into destination/<name of source>
+func nestDestination(source, destination string) (string, error) {
+	if strings.HasSuffix(source, "/") || strings.HasSuffix(source, string(filepath.Separator)) {
+		return destination, nil
+	}
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(abs)
+	if name == string(filepath.Separator) || name == "." {
+		// The root of a drive has no name to nest under
+		return destination, nil
+	}
+	return filepath.Join(destination, name), nil
+}
+
+// CopyFile copies the file name from src to dst, reporting to bar
+func CopyFile(src fs.FS, dst DestFS, name string, config Config, bar progress) error {
+	return copyFile(src, dst, name, config, bar, nil, nil)
 }
 
-// CopyFile copies a file from source to destination
-func CopyFile(sourceFile, destFile string) error {
-	source, err := os.Open(sourceFile)
+// copyFile is CopyFile also feeding the copied data to h, unless nil, and
+// timing its steps under the span sp, unless nil
+func copyFile(src fs.FS, dst DestFS, name string, config Config, bar progress, h hash.Hash, sp *span) error {
+	step := startSpan("open", sp)
+	source, err := openSource(src, name, config)
+	step.end(err)
 	if err != nil {
 		return err
 	}
 	defer source.Close()
 
-	destination, err := os.Create(destFile)
+	step = startSpan("create", sp)
+	destination, err := createDestination(dst, name, config)
+	step.end(err)
 	if err != nil {
 		return err
 	}
 	defer destination.Close()
 
-	sourceInfo, err := source.Stat()
-	if err != nil {
-		return err
+	if config.Preallocate {
+		if err := preallocateFile(destination, source); err != nil {
+			return fmt.Errorf("cannot preallocate: %w", err)
+		}
 	}
 
-	bar := progressbar.NewOptions64(
-		sourceInfo.Size(),
-		progressbar.OptionSetDescription(fmt.Sprintf("Copying %s", filepath.Base(sourceFile))),
-		progressbar.OptionSetWriter(os.Stdout),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionShowCount(),
-		progressbar.OptionThrottle(65*time.Millisecond),
-		progressbar.OptionSetWidth(40),
-		progressbar.OptionClearOnFinish(),
-	)
+	reader := sp.reader(bandwidth.reader(faults.reader(source)))
+	writer := sp.writer(faults.writer(destination))
 
-	buf := make([]byte, 32*1024) // 32KB buffer
-	start := time.Now()
+	buf := make([]byte, config.BufferSize)
+	if config.DirectIO {
+		buf = alignedBuffer(roundUpAligned(config.BufferSize))
+	}
 	for {
-		n, err := source.Read(buf)
+		n, err := reader.Read(buf)
 		if n > 0 {
-			_, writeErr := destination.Write(buf[:n])
+			_, writeErr := writer.Write(buf[:n])
 			if writeErr != nil {
 				return writeErr
 			}
+			if h != nil {
+				h.Write(buf[:n])
+			}
 			bar.Add(n)
-
-			elapsed := time.Since(start).Seconds()
-			speed := float64(bar.State().CurrentBytes) / elapsed
-			bar.Describe(fmt.Sprintf("%s (%.2f KB/s)", filepath.Base(sourceFile), speed/1024))
 		}
 		if err != nil {
 			if err == io.EOF {
@@ -89,18 +589,32 @@ func CopyFile(sourceFile, destFile string) error {
 		}
 	}
 
+	// Where a remote stores what it was sent
+	step = startSpan("close", sp)
+	if config.Fsync {
+		if err := flushFile(dst, name, destination); err != nil {
+			step.end(err)
+			return err
+		}
+	}
+	err = destination.Close()
+	step.end(err)
+	if err != nil {
+		return err
+	}
+
 	return bar.Finish()
 }
 
-// FilesAreEqual checks if two files are equal by comparing their size and modification time
-func FilesAreEqual(sourceFile, destFile string) (bool, error) {
-	sourceInfo, err := os.Stat(sourceFile)
+// FilesAreEqual checks if the file name is equal in src and dst by comparing their size and modification time
+func FilesAreEqual(src fs.FS, dst DestFS, name string) (bool, error) {
+	sourceInfo, err := fs.Stat(src, name)
 	if err != nil {
 		return false, err
 	}
 
-	destInfo, err := os.Stat(destFile)
-	if os.IsNotExist(err) {
+	destInfo, err := dst.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
 		return false, nil
 	}
 	if err != nil {
@@ -111,150 +625,1056 @@ func FilesAreEqual(sourceFile, destFile string) (bool, error) {
 		return false, nil
 	}
 
-	if !sourceInfo.ModTime().Equal(destInfo.ModTime()) {
+	// Sources such as embed.FS have no modification times to compare
+	if sourceInfo.ModTime().IsZero() {
+		return sameContent(src, dst, name)
+	}
+
+	if !sameModTime(dst, sourceInfo.ModTime(), destInfo.ModTime()) {
 		return false, nil
 	}
 
 	return true, nil
 }
 
-// LogCopiedFile logs the copied file to the console and to the log file
-func LogCopiedFile(logFile, filePath string, mu *sync.Mutex) error {
+// sameContent compares the file name in src and dst byte by byte
+func sameContent(src fs.FS, dst DestFS, name string) (bool, error) {
+	source, err := src.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer source.Close()
+
+	destination, err := dst.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer destination.Close()
+
+	sourceBuf := make([]byte, defaultBufferSize)
+	destBuf := make([]byte, defaultBufferSize)
+	for {
+		n, sourceErr := io.ReadFull(source, sourceBuf)
+		m, destErr := io.ReadFull(destination, destBuf)
+		if !bytes.Equal(sourceBuf[:n], destBuf[:m]) {
+			return false, nil
+		}
+		if sourceErr == io.EOF || sourceErr == io.ErrUnexpectedEOF {
+			return destErr == sourceErr, nil
+		}
+		if sourceErr != nil {
+			return false, sourceErr
+		}
+		if destErr != nil {
+			return false, destErr
+		}
+	}
+}
+
+// Formats of the log file
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Results of a transferRecord
+const (
+	resultCopied = "copied"
+	resultFailed = "failed"
+)
+
+// transferRecord is the entry of the log file for a copy
+type transferRecord struct {
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Bytes       int64     `json:"bytes"`
+	Seconds     float64   `json:"seconds"`
+	Speed       float64   `json:"bytes_per_second"`
+	Hash        string    `json:"hash,omitempty"`
+	Worker      int       `json:"worker"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func newTransferRecord(worker int, source, destination string, bytes int64, duration time.Duration) transferRecord {
+	r := transferRecord{
+		Time:        time.Now(),
+		Source:      source,
+		Destination: destination,
+		Bytes:       bytes,
+		Seconds:     duration.Seconds(),
+		Worker:      worker,
+		Result:      resultCopied,
+	}
+	if r.Seconds > 0 {
+		r.Speed = float64(bytes) / r.Seconds
+	}
+	return r
+}
+
+// String formats the record as a line of the text log
+func (r transferRecord) String() string {
+	line := fmt.Sprintf("%s: %s <- %s: ", r.Time.Format(time.RFC3339), r.Destination, r.Source)
+	duration := time.Duration(r.Seconds * float64(time.Second))
+	if duration >= time.Second {
+		duration = duration.Round(time.Millisecond)
+	} else {
+		duration = duration.Round(time.Microsecond)
+	}
+	if r.Result == resultFailed {
+		return line + fmt.Sprintf("failed by worker %d after %s: %s", r.Worker, duration, r.Error)
+	}
+	line += fmt.Sprintf("copied %s in %s at %s/s by worker %d", formatBytes(r.Bytes), duration, formatBytes(int64(r.Speed)), r.Worker)
+	if r.Hash != "" {
+		line += ", " + r.Hash
+	}
+	return line
+}
+
+// LogCopiedFile logs a copy to the log file, and to the console when it
+// succeeded, failures being reported as errors already
+func LogCopiedFile(config Config, record transferRecord, mu *sync.Mutex) error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	logEntry := fmt.Sprintf("%s: %s\n", time.Now().Format(time.RFC3339), filePath)
+	logEntry := record.String() + "\n"
+	if config.LogFormat == logFormatJSON {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		logEntry = string(data) + "\n"
+	}
 	if _, err := f.WriteString(logEntry); err != nil {
 		return err
 	}
 
-	fmt.Println(logEntry)
+	if record.Result == resultCopied {
+		copiedf("%s\n", record)
+	}
 	return nil
 }
 
-// Function to check if a file extension is in the skip list
-func shouldSkipFile(path string, skipExtensions []string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	for _, skipExt := range skipExtensions {
-		if ext == skipExt {
-			return true
+// syncer holds the state shared by the workers of a sync run
+type syncer struct {
+	src     fs.FS
+	dst     DestFS
+	stored  DestFS // dst as storedDest sees it, without the versions of append_only
+	config  Config
+	stats   *Stats
+	tracker *transferTracker
+	filter  *filter
+	state   *State // nil without a state_file
+	job     *job
+	journal *journal       // nil without a journal_file
+	meta    *metadataIndex // nil without metadata_sidecar
+	names   *targetNames   // nil without target_fs
+	limit   *pathLimit     // nil for destinations not on disk
+	report  *runReport     // nil without report_file and html_report
+	breaker *breaker
+	caps    *transferCaps // nil without max_transfer and max_files
+	logMu   sync.Mutex
+	dirs    pendingDirs
+
+	movedMu   sync.Mutex
+	movedDirs map[string]bool // the source directories files were moved out of
+}
+
+// taskKind is what a task of the plan does at the destination
+type taskKind int
+
+const (
+	taskMkdir  taskKind = iota // create a directory of the source
+	taskCopy                   // copy a file of the source, unless the destination has it
+	taskDelete                 // delete a file gone from the source
+)
+
+// task is an operation of the plan of a run. The planner hands directories
+// straight to the workers and files to the checkers, which pass on those
+// that need copying; deletions follow once the copies are over.
+type task struct {
+	kind taskKind
+	name string
+	info fs.FileInfo // of the source file, once checked
+}
+
+// pendingDirs are the directories of the plan handed to the workers and
+// not created yet, which the copies into them wait for. The planner adds
+// each directory before anything it holds.
+type pendingDirs struct {
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+// add records that the directory name is about to be handed out
+func (d *pendingDirs) add(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == nil {
+		d.pending = make(map[string]chan struct{})
+	}
+	d.pending[name] = make(chan struct{})
+}
+
+// done records that the worker is done with the directory name
+func (d *pendingDirs) done(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ready, ok := d.pending[name]; ok {
+		close(ready)
+		delete(d.pending, name)
+	}
+}
+
+// wait waits until the worker is done with the directory name, if pending
+func (d *pendingDirs) wait(name string) {
+	d.mu.Lock()
+	ready, ok := d.pending[name]
+	d.mu.Unlock()
+	if ok {
+		<-ready
+	}
+}
+
+// checker compares the files of the plan with the destination, hashing
+// them in checksum mode, and hands those that need copying to the workers.
+// The small files of a batch go together, the others alone.
+func (s *syncer) checker(id int, plan <-chan []task, tasks chan<- []task, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for batch := range plan {
+		var small []task
+		for _, t := range batch {
+			s.breaker.wait()
+			info, needed, ok := s.checkEntry(id, t.name)
+			t.info = info
+			switch {
+			case needed && len(batch) > 1 && info.Size() <= smallFileSize:
+				small = append(small, t)
+			case needed:
+				tasks <- []task{t}
+			case ok:
+				s.job.markDone(t.name, 0)
+			}
+		}
+		if len(small) > 0 {
+			tasks <- small
 		}
 	}
-	return false
 }
 
-// Worker function for copying files
-func worker(id int, sourceDir string, jobs <-chan string, destDir string, logFile string, skipExtensions []string, wg *sync.WaitGroup, mu *sync.Mutex) {
+// worker carries out the tasks of the plan: it creates the directories,
+// copies the files the checkers found different and deletes the files
+// gone from the source
+func (s *syncer) worker(id int, tasks <-chan []task, wg *sync.WaitGroup) {
 	defer wg.Done()
-	for path := range jobs {
-		relativePath, err := filepath.Rel(sourceDir, path)
-		if err != nil {
-			fmt.Printf("Worker %d: Error getting relative path for %s: %v\n", id, path, err)
-			continue
+	for batch := range tasks {
+		for _, t := range batch {
+			switch t.kind {
+			case taskMkdir:
+				if s.makeDir(id, t.name) {
+					s.job.markDone(t.name, 0)
+				}
+			case taskDelete:
+				s.deleteEntry(t.name)
+			case taskCopy:
+				s.copyTask(id, t)
+			}
 		}
+	}
+}
 
-		destPath := filepath.Join(destDir, relativePath)
+// copyTask copies the file of t within the caps, once its directory exists
+func (s *syncer) copyTask(id int, t task) {
+	// Left out of the job, which a resumed run then copies
+	if reason, ok := s.caps.take(t.info.Size()); !ok {
+		skippedf("Worker %d: Skipping %s: %s\n", id, displayPath(s.src, t.name), reason)
+		s.stats.addSkipped()
+		s.report.add(actionSkipped, t.name, t.info.Size(), 0, reason)
+		return
+	}
+	s.dirs.wait(path.Dir(t.name))
+	s.breaker.wait()
+	copied, ok := s.copyEntry(id, t.name, t.info)
+	if ok {
+		s.job.markDone(t.name, copied)
+	}
+	if s.config.NiceIO {
+		time.Sleep(niceIOPause)
+	}
+}
 
-		// Skip PDF files
-		if shouldSkipFile(path, skipExtensions) {
-			continue
+// makeDir creates the source directory name at the destination, returning
+// whether it is done with
+func (s *syncer) makeDir(id int, name string) bool {
+	defer s.dirs.done(name)
+	info, ok := s.admit(id, name)
+	if !ok {
+		return info != nil
+	}
+	if !info.IsDir() {
+		s.changedKind(id, name, info)
+		return false
+	}
+
+	s.meta.record(name, info)
+	entry := journalEntry{Op: opMkdir, Path: name}
+	if s.journal != nil {
+		_, err := s.dst.Stat(name)
+		entry.Created = errors.Is(err, fs.ErrNotExist)
+	}
+	if !entry.Created {
+		createDirectory(s.dst, name, s.config)
+		return true
+	}
+	seq := s.journal.begin(entry)
+	s.journal.end(seq, createDirectory(s.dst, name, s.config))
+	return true
+}
+
+// changedKind skips the entry name, a file in the plan that is now a
+// directory or the other way around, for the next run to scan again
+func (s *syncer) changedKind(id int, name string, info fs.FileInfo) {
+	reason := tr("changed type since the scan")
+	skippedf("Worker %d: Skipping %s: %s\n", id, displayPath(s.src, name), reason)
+	s.stats.addSkipped()
+	s.report.add(actionSkipped, name, info.Size(), 0, reason)
+}
+
+// admit reads the info of the source entry name and checks it against the
+// filters and limits of the destination, returning whether it goes on.
+// Entries that do not are reported, and done with unless the info is nil.
+func (s *syncer) admit(id int, name string) (fs.FileInfo, bool) {
+	path := displayPath(s.src, name)
+
+	info, err := fs.Stat(s.src, name)
+	if err != nil {
+		errorf("Worker %d: Error reading %s: %v\n", id, path, err)
+		s.stats.addError()
+		s.report.add(actionFailed, name, 0, 0, err.Error())
+		return nil, false
+	}
+
+	// Skip PDF files and the other exclusions
+	if reason, ok := s.filter.exclude(name, info); ok {
+		skippedf("Worker %d: Skipping %s: %s\n", id, path, reason)
+		s.stats.addSkipped()
+		s.report.add(actionExcluded, name, info.Size(), 0, reason)
+		return info, false
+	}
+	if reason, ok := s.checkTargetName(name); ok {
+		errorf("Worker %d: Not copying %s: %s\n", id, path, reason)
+		s.stats.addError()
+		s.report.add(actionFailed, name, info.Size(), 0, reason)
+		return info, false
+	}
+	// Reported with the plan, before the copy started
+	if reason, ok := s.checkPathLength(name); ok {
+		debugf("Worker %d: Not copying %s: %s\n", id, path, reason)
+		s.stats.addError()
+		s.report.add(actionFailed, name, info.Size(), 0, reason)
+		return info, false
+	}
+	return info, true
+}
+
+// checkEntry compares the source file name with the destination, returning
+// its info, whether it needs copying and whether it is done with otherwise
+func (s *syncer) checkEntry(id int, name string) (fs.FileInfo, bool, bool) {
+	path := displayPath(s.src, name)
+	destPath := displayPath(s.dst, name)
+
+	info, ok := s.admit(id, name)
+	if !ok {
+		return info, false, info != nil
+	}
+	if info.IsDir() {
+		s.changedKind(id, name, info)
+		return info, false, false
+	}
+
+	// Opening a named pipe blocks until something writes to it
+	if isSpecial(info) {
+		return s.specialFile(id, name, info)
+	}
+
+	if failure, ok := s.state.quarantined(name); ok {
+		reason := fmt.Sprintf(tr("quarantined after failing %d runs in a row"), failure.Runs)
+		skippedf("Worker %d: Skipping %s: %s\n", id, path, reason)
+		s.stats.addSkipped()
+		s.report.add(actionSkipped, name, info.Size(), 0, reason)
+		return info, false, true
+	}
+
+	// Check if the file already exists and is identical
+	needed, reason, err := shouldCopy(s.src, s.dst, name, s.config, s.state)
+	if err != nil {
+		errorf("Worker %d: Error comparing files %s and %s: %v\n", id, path, destPath, err)
+		s.stats.addError()
+		s.breaker.failure()
+		s.state.recordFailure(name, err, s.config.QuarantineAfter)
+		s.report.add(actionFailed, name, info.Size(), 0, err.Error())
+		return info, false, false
+	}
+
+	if !needed {
+		skippedf("Worker %d: Skipping %s: %s\n", id, path, tr(reason))
+		s.stats.addSkipped()
+		s.report.add(actionSkipped, name, info.Size(), 0, tr(reason))
+		if reason == skipEqual || reason == skipChecksum {
+			s.state.recordSynced(name, info, "")
+			s.meta.record(name, info)
+			if s.config.Move {
+				s.moveSource(id, name)
+			}
 		}
+		return info, false, true
+	}
+	return info, true, false
+}
 
-		// Create directories if needed
-		if info, err := os.Stat(path); err == nil && info.IsDir() {
-			createDirectory(destPath)
-			continue
+// copyEntry copies the file name with the given source info, returning the
+// bytes copied and whether the file is done with
+func (s *syncer) copyEntry(id int, name string, info fs.FileInfo) (int64, bool) {
+	// An existing file is never replaced with append_only: the new version
+	// gets a name of its own, which the name leads to from now on
+	entry := journalEntry{Op: opCopy, Path: name}
+	previousVersion := name
+	if s.config.AppendOnly {
+		if _, err := s.dst.Stat(name); err == nil {
+			entry.Path = versionName(name, s.stats.Start)
+			previousVersion = s.state.setVersion(name, entry.Path)
 		}
+	}
 
-		// Check if the file already exists and is identical
-		equal, err := FilesAreEqual(path, destPath)
-		if err != nil {
-			fmt.Printf("Worker %d: Error comparing files %s and %s: %v\n", id, path, destPath, err)
-			continue
+	path := displayPath(s.src, name)
+	destPath := displayPath(s.dst, name)
+
+	// Copy the file
+	logf("Worker %d: Copying %s to %s\n", id, path, destPath)
+	diagnostics.copying(id, name)
+	defer diagnostics.copying(id, "")
+	emitEvent(eventFileStart, &fileStartEvent{File: name, Size: info.Size()})
+	start := time.Now()
+
+	// Hash while copying in checksum mode, so that the next comparison can
+	// use the recorded hash instead of reading the destination
+	var h hash.Hash
+	if s.state != nil && s.config.Checksum {
+		h = newHash(s.config.Hash)
+	}
+	if s.journal != nil || s.config.BackupDir != "" {
+		_, err := s.dst.Stat(name)
+		entry.Created = errors.Is(err, fs.ErrNotExist)
+		if !entry.Created {
+			entry.Backup = s.backupPath(name)
 		}
+	}
 
-		if equal {
-			continue
+	// The version being replaced goes to the backup_dir first
+	var err error
+	if entry.Backup != "" {
+		seq := s.journal.begin(journalEntry{Op: opBackup, Path: name, Backup: entry.Backup})
+		err = s.moveToBackup(name, entry.Backup)
+		s.journal.end(seq, err)
+	}
+	if err == nil {
+		seq := s.journal.begin(entry)
+		sp := startSpan("copy", nil, attr("gosync.file", name), attr("gosync.size", info.Size()), attr("gosync.worker", id))
+		err = copyFile(s.src, s.dst, name, s.config, newProgress(id, path, info.Size(), s.tracker), h, sp)
+		sp.end(err)
+		s.journal.end(seq, err)
+	}
+	elapsed := time.Since(start)
+	record := newTransferRecord(id, path, destPath, info.Size(), elapsed)
+	done := &fileDoneEvent{File: name, Bytes: info.Size(), Seconds: elapsed.Seconds()}
+	if err != nil {
+		done.Bytes, done.Error = 0, err.Error()
+	}
+	emitEvent(eventFileDone, done)
+	if err != nil {
+		errorf("Worker %d: Error copying file %s to %s: %v\n", id, path, destPath, err)
+		s.stats.addError()
+		if s.config.AppendOnly {
+			s.state.setVersion(name, previousVersion)
+		}
+		s.breaker.failure()
+		s.state.recordFailure(name, err, s.config.QuarantineAfter)
+		record.Result, record.Error = resultFailed, err.Error()
+		s.report.add(actionFailed, name, info.Size(), elapsed, err.Error())
+		if err := LogCopiedFile(s.config, record, &s.logMu); err != nil {
+			errorf("Worker %d: Error logging file %s: %v\n", id, destPath, err)
 		}
+		return 0, false
+	}
+	s.breaker.success()
+	s.stats.addCopied(path, info.Size(), elapsed)
+	s.report.add(actionCopied, name, info.Size(), elapsed, "")
+
+	var sum string
+	if h != nil {
+		sum = formatHash(s.config.Hash, h.Sum(nil))
+	}
+	s.state.recordSynced(name, info, sum)
+	s.meta.record(name, info)
+	record.Hash = sum
 
-		// Copy the file
-		fmt.Printf("Worker %d: Copying %s to %s\n", id, path, destPath)
-		if err := CopyFile(path, destPath); err != nil {
-			fmt.Printf("Worker %d: Error copying file %s to %s: %v\n", id, path, destPath, err)
-			time.Sleep(30 * time.Second)
-			continue
+	// Apply the configured permission policy
+	if mode, ok := s.config.filePermissions(); ok {
+		if err := s.dst.Chmod(name, mode); err != nil {
+			errorf("Worker %d: Error setting permissions for %s: %v\n", id, destPath, err)
+			s.stats.addError()
 		}
+	}
 
-		// Set the modification time of the copied file to match the source
-		if info, err := os.Stat(path); err == nil {
-			if err := os.Chtimes(destPath, time.Now(), info.ModTime()); err != nil {
-				fmt.Printf("Worker %d: Error setting times for %s: %v\n", id, destPath, err)
-			}
+	// Copy alternate data streams before touching the times, since
+	// writing a stream updates the modification time of the file
+	if s.config.CopyADS {
+		if err := s.copyAlternateStreams(name); err != nil {
+			errorf("Worker %d: Error copying alternate data streams of %s: %v\n", id, path, err)
+			s.stats.addError()
 		}
+	}
+
+	// Set the times of the copied file to match the source
+	if err := preserveFileTimes(info, s.dst, name, s.config.PreserveTimes); err != nil {
+		errorf("Worker %d: Error setting times for %s: %v\n", id, destPath, err)
+		s.stats.addError()
+	}
+
+	// Log the copied file
+	if err := LogCopiedFile(s.config, record, &s.logMu); err != nil {
+		errorf("Worker %d: Error logging file %s: %v\n", id, destPath, err)
+		s.stats.addError()
+	}
 
-		// Log the copied file
-		if err := LogCopiedFile(logFile, destPath, mu); err != nil {
-			fmt.Printf("Worker %d: Error logging file %s: %v\n", id, destPath, err)
+	if len(s.config.OnCopy) > 0 {
+		if err := runHook(s.config, name, path, destPath, info); err != nil {
+			errorf("Worker %d: Error running on_copy for %s: %v\n", id, destPath, err)
+			s.stats.addError()
 		}
 	}
+
+	if s.config.Move {
+		s.moveSource(id, name)
+	}
+	return info.Size(), true
+}
+
+// copyAlternateStreams copies the alternate data streams of name, which
+// only exist when both sides are on disk
+func (s *syncer) copyAlternateStreams(name string) error {
+	src, srcOK := s.src.(localFS)
+	dst, dstOK := s.dst.(localFS)
+	if !srcOK || !dstOK {
+		return nil
+	}
+	return copyAlternateStreams(src.localPath(name), dst.localPath(name))
 }
 
 // SyncDirectories synchronizes files between two directories excluding PDFs using goroutines
-func SyncDirectories(sourceDir, destDir, logFile string, workers int, skipExtensions []string) error {
-	var wg sync.WaitGroup
-	mu := &sync.Mutex{}
-	jobs := make(chan string, 100)
+func SyncDirectories(config Config) (*Stats, error) {
+	if config.DestinationFormat == formatChunks {
+		return syncChunks(newOSFS(config.Source), config)
+	}
+	return syncFS(newOSFS(config.Source), openDestination(config), config)
+}
+
+// SyncFS synchronizes any fs.FS (embed.FS, zip.Reader, fstest.MapFS...) into
+// the destination directory of config, which Source is ignored for
+func SyncFS(src fs.FS, config Config) (*Stats, error) {
+	if config.DestinationFormat == formatChunks {
+		return syncChunks(src, config)
+	}
+	return syncFS(src, openDestination(config), config)
+}
+
+// syncFS synchronizes the files of src into dst using goroutines
+func syncFS(src fs.FS, dst DestFS, config Config) (*Stats, error) {
+	var checkers, workers sync.WaitGroup
+	plan := make(chan []task, config.QueueDepth)
+	tasks := make(chan []task, config.QueueDepth)
+	dst = withTargetNames(dst, config)
+	s := &syncer{src: src, dst: dst, stored: dst, config: config, stats: newStats()}
+	s.stats.top = newTopFiles(config.TopFiles)
+	s.caps = newTransferCaps(config, s.stats.Start)
+	if config.TargetFS != "" {
+		s.names = newTargetNames()
+	}
+	s.limit = newPathLimit(dst, config)
+	diagnostics.start(s.stats, func() string {
+		return fmt.Sprintf("%d of %d batches of the plan waiting for the checkers, %d of %d batches of tasks for the workers, of up to %d each", len(plan), cap(plan), len(tasks), cap(tasks), config.BatchFiles)
+	})
+
+	if err := checkDestination(dst, config); err != nil {
+		return s.stats, err
+	}
+	if _, ok := src.(removeFS); config.Move && !ok {
+		return s.stats, errSourceReadOnly
+	}
+
+	filter, err := newFilter(config)
+	if err != nil {
+		return s.stats, err
+	}
+	s.filter = filter
+	if len(config.ExcludeCmd) > 0 {
+		if err := filter.runExcludeCmd(src, config.ExcludeCmd); err != nil {
+			return s.stats, fmt.Errorf("exclude_cmd failed: %v", err)
+		}
+	}
 
-	// Start workers
-	for w := 1; w <= workers; w++ {
-		wg.Add(1)
-		go worker(w, sourceDir, jobs, destDir, logFile, skipExtensions, &wg, mu)
+	if config.NiceIO {
+		if err := lowerPriority(); err != nil {
+			errorf("Cannot lower the priority of the process: %v\n", err)
+		}
 	}
 
-	// Walk through the source directory and send jobs to the workers
-	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+	if bandwidth, err = newBandwidthLimiter(config.BwLimit); err != nil {
+		return s.stats, err
+	}
+
+	if config.StateFile != "" {
+		state, err := loadState(config.StateFile)
 		if err != nil {
-			return err
+			return s.stats, fmt.Errorf("cannot read state file: %v", err)
 		}
-		jobs <- path
-		return nil
-	})
+		s.state = state
+	}
+
+	if config.AppendOnly {
+		dst = withVersions(dst, s.state)
+		s.dst = dst
+	}
+
+	if config.usesListingCache() {
+		ttl, _ := time.ParseDuration(config.ListingCacheTTL)
+		if n := baseDest(dst).(*rcloneFS).loadListings(s.state.Listings, ttl); n > 0 {
+			logf("Using %d cached listings of the destination\n", n)
+		}
+	}
+
+	if config.MetadataSidecar {
+		s.meta = newMetadataIndex()
+	}
+	if s.report, err = newRunReport(config); err != nil {
+		return s.stats, fmt.Errorf("cannot write report: %v", err)
+	}
+	defer func() {
+		if err := s.report.close(); err != nil {
+			errorf("Error writing the report %s: %v\n", config.ReportFile, err)
+		}
+	}()
+
+	backoff, _ := time.ParseDuration(config.ErrorBackoff)
+	s.breaker = newBreaker(config.ErrorThreshold, backoff, func() error { return checkDestination(dst, config) })
+
+	if _, ok := dst.(localFS); config.BackupDir != "" && !ok {
+		return s.stats, errBackupUnsupported
+	}
+	if _, ok := dst.(localFS); config.DetectRenames && !ok {
+		return s.stats, errRenamesUnsupported
+	}
+
+	// Report what the previous run left in flight before starting over
+	if config.JournalFile != "" {
+		recoverJournal(config.JournalFile, dst)
+		if s.journal, err = startJournal(config); err != nil {
+			return s.stats, fmt.Errorf("cannot write journal: %v", err)
+		}
+		defer s.journal.close()
+	}
 
-	close(jobs)
-	wg.Wait()
-	return err
+	// Pick up an interrupted run, or find out how much has to be copied before starting
+	var scan scanResult
+	var done map[string]bool
+	var doneBytes, deletions int64
+	if config.Resume {
+		s.job, scan, done, doneBytes, err = resumeJob(config)
+		switch {
+		case errors.Is(err, errNoJob):
+			logf("No interrupted job to resume, starting a new one\n")
+		case err != nil:
+			return s.stats, err
+		default:
+			logf("Resuming the job: %d of %d entries already done\n", len(done), len(scan.Paths))
+		}
+	}
+
+	if s.job == nil {
+		emitEvent(eventScanStart, &scanStartEvent{Source: config.Source, Destination: config.Destination})
+		notifySystemd("STATUS=" + tr("Scanning the source"))
+		diagnostics.setPhase("scanning")
+		defer prefetchListings(dst, config.ListWorkers)()
+		sp := startSpan("scan", nil)
+		scan, err = scanSource(src, dst, config, filter)
+		sp.set("gosync.files", scan.Files)
+		sp.set("gosync.bytes", scan.Bytes)
+		sp.end(err)
+		if err != nil {
+			return s.stats, err
+		}
+		plan := startSpan("plan", nil)
+		if s.state != nil {
+			run := s.state.plannedRun(s.stats.Start, &scan)
+			if err := checkAnomaly(s.state, run, config); err != nil {
+				return s.stats, err
+			}
+			// The first run copies everything, which says nothing of the usual runs
+			if len(s.state.Files) > 0 {
+				s.state.recordRun(run)
+			}
+		}
+
+		var renames []plannedRename
+		if config.DetectRenames {
+			renames = s.detectRenames(&scan)
+		}
+
+		if config.PropagateDeletes && s.state != nil {
+			deletions = s.plannedDeletions(&scan, renames)
+			logf("%d files (%s) to copy, %d to delete\n", scan.Files, formatBytes(scan.Bytes), deletions)
+		} else {
+			logf("%d files (%s) to copy\n", scan.Files, formatBytes(scan.Bytes))
+		}
+		if len(scan.TooLong) > 0 {
+			errorf("%d destination paths are too long and will not be copied:\n", len(scan.TooLong))
+			for _, name := range scan.TooLong {
+				reason, _ := s.checkPathLength(name)
+				errorf("  %s: %s\n", displayPath(dst, name), reason)
+			}
+		}
+
+		if err := checkFreeSpace(dst, scan, config); err != nil {
+			return s.stats, err
+		}
+		if config.Confirm && !*yes && !confirmRun() {
+			return s.stats, errNotConfirmed
+		}
+		deletions += s.applyRenames(&scan, renames)
+
+		plan.set("gosync.deletions", deletions)
+		plan.end(nil)
+		if s.job, err = startJob(config, scan); err != nil {
+			return s.stats, fmt.Errorf("cannot write job file: %v", err)
+		}
+		emitEvent(eventPlan, &planEvent{Files: scan.Files, Bytes: scan.Bytes, Deletions: deletions})
+	} else {
+		emitEvent(eventPlan, &planEvent{Files: scan.Files, Bytes: scan.Bytes - doneBytes, Resumed: true})
+	}
+
+	notifySystemd("STATUS=" + fmt.Sprintf(tr("Copying %d files (%s)"), scan.Files, formatBytes(scan.Bytes-doneBytes)))
+	diagnostics.setPhase("copying")
+	s.tracker = newTransferTracker(scan.Bytes - doneBytes)
+	defer s.tracker.Stop()
+
+	// Several workers copying at once get a bar each
+	if config.Worker > 1 && useBars() && enableVirtualTerminal(os.Stdout) {
+		bars := startMultiBar(config.Worker, s.tracker)
+		defer bars.Stop()
+	}
+
+	// Start workers, and the checkers feeding them, numbered after them
+	for w := 1; w <= config.Worker; w++ {
+		workers.Add(1)
+		go s.worker(w, tasks, &workers)
+	}
+	for c := 1; c <= config.HashWorkers; c++ {
+		checkers.Add(1)
+		go s.checker(config.Worker+c, plan, tasks, &checkers)
+	}
+
+	// Plan the scanned entries until max_duration: the directories go to
+	// the workers ahead of their contents, and the files to the checkers,
+	// batch_files at a time
+	batch := make([]task, 0, config.BatchFiles)
+	for i := range scan.Paths {
+		if s.caps.expired() {
+			unsent := 0
+			for ; i < len(scan.Paths); i++ {
+				if !done[scan.nth(i)] {
+					unsent++
+				}
+			}
+			s.caps.stop(unsent)
+			break
+		}
+		t := scan.task(src, i)
+		switch {
+		case done[t.name]:
+		case t.kind == taskMkdir:
+			s.dirs.add(t.name)
+			tasks <- []task{t}
+		default:
+			batch = append(batch, t)
+		}
+		if len(batch) == config.BatchFiles {
+			plan <- batch
+			batch = make([]task, 0, config.BatchFiles)
+		}
+	}
+	if len(batch) > 0 {
+		plan <- batch
+	}
+
+	close(plan)
+	checkers.Wait()
+	close(tasks)
+	workers.Wait()
+	s.caps.report()
+	diagnostics.setPhase("finishing")
+
+	if config.Move {
+		s.removeEmptySourceDirs(scan.Paths)
+	}
+
+	if s.state != nil {
+		s.state.observeSource(scan.inSource)
+		if config.PropagateDeletes {
+			s.propagateDeletions()
+		}
+		if config.DeleteExcluded {
+			s.deleteExcluded()
+		}
+
+		s.reportQuarantine()
+
+		if err := s.state.save(config.StateFile); err != nil {
+			return s.stats, fmt.Errorf("cannot write state file: %v", err)
+		}
+	}
+
+	if err := s.meta.flush(dst); err != nil {
+		s.stats.addError()
+	}
+
+	// Folders the caps left empty still have files to come
+	if config.PruneEmptyDirs && !s.caps.cutShort() {
+		s.pruneEmptyDirs()
+	}
+
+	if config.BackupDir != "" && config.versionedBackups() {
+		s.pruneBackups()
+	}
+
+	if config.ManifestFile != "" {
+		if err := s.writeManifest(); err != nil {
+			errorf("Error writing the manifest %s: %v\n", config.ManifestFile, err)
+			s.stats.addError()
+		}
+	}
+
+	if config.HTMLReport != "" {
+		if err := writeHTMLReport(config.HTMLReport, s.stats, s.tracker.History(), s.report.Failures()); err != nil {
+			errorf("Error writing the report %s: %v\n", config.HTMLReport, err)
+		}
+	}
+
+	if config.usesListingCache() {
+		if err := s.saveListings(); err != nil {
+			return s.stats, fmt.Errorf("cannot write state file: %v", err)
+		}
+	}
+
+	if s.caps.cutShort() {
+		s.job.keep()
+	} else {
+		s.job.finish()
+	}
+	return s.stats, nil
 }
 
-func createDirectory(path string) {
-	err := os.MkdirAll(path, os.ModePerm)
+func createDirectory(dst DestFS, name string, config Config) error {
+	path := displayPath(dst, name)
+	mode, force := config.dirPermissions()
+	err := dst.MkdirAll(name, mode)
 	if err != nil {
-		fmt.Printf("Error creating directory %s: %v\n", path, err)
-		return
+		errorf("Error creating directory %s: %v\n", path, err)
+		return err
+	}
+
+	if force {
+		if err := dst.Chmod(name, mode); err != nil {
+			errorf("Error setting permissions for %s: %v\n", path, err)
+		}
 	}
+
+	if config.Fsync {
+		if err := flushParent(dst, name); err != nil {
+			errorf("Error flushing directory %s: %v\n", path, err)
+		}
+	}
+	return nil
 }
 
 func main() {
+	flag.Parse()
+	if *progressFormat != progressFormatBar && *progressFormat != progressFormatJSON {
+		errorf("Invalid progress format %q\n", *progressFormat)
+		os.Exit(2)
+	}
+
 	// Load configuration
 	config, err := ReadConfig("config.json")
 	if err != nil {
-		fmt.Println("Error reading config:", err)
+		errorf("Error reading config: %v\n", err)
+		return
+	}
+	setLanguage(config.Language)
+	applyMemoryLimit(config)
+	applyProxy(config)
+
+	if *audit {
+		if err := runAudit(config); err != nil {
+			errorf("Error auditing the destination: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "bench":
+		if err := runBench(config, flag.Args()[1:]); err != nil {
+			errorf("Error running benchmark: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "du":
+		if err := runDu(config); err != nil {
+			errorf("Error scanning the source: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "diff":
+		err := runDiff(config, flag.Args()[1:])
+		if errors.Is(err, errDifferences) {
+			os.Exit(1)
+		}
+		if err != nil {
+			errorf("Error comparing the source and the destination: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	case "export":
+		if err := runExport(config, flag.Args()[1:]); err != nil {
+			errorf("Error exporting the state: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "journal":
+		if err := runJournal(config); err != nil {
+			errorf("Error reading the journal: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "quarantine":
+		if err := runQuarantine(config, flag.Args()[1:]); err != nil {
+			errorf("Error reading the quarantine: %v\n", err)
+			os.Exit(1)
+		}
 		return
+	case "ls":
+		if err := runLs(config, flag.Args()[1:]); err != nil {
+			errorf("Error listing: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "rollback":
+		if err := runRollback(config); err != nil {
+			errorf("Error rolling back: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "verify-manifest":
+		err := runVerifyManifest(config, flag.Args()[1:])
+		if errors.Is(err, errManifestMismatch) {
+			os.Exit(1)
+		}
+		if err != nil {
+			errorf("Error verifying the manifest: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	case "gc":
+		if err := runGC(config, flag.Args()[1:]); err != nil {
+			errorf("Error collecting garbage: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "snapshots":
+		if err := runSnapshots(config); err != nil {
+			errorf("Error listing the snapshots: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "restore":
+		if err := runRestore(config, flag.Args()[1:]); err != nil {
+			errorf("Error restoring the snapshot: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "restore-metadata":
+		if err := runRestoreMetadata(config, flag.Args()[1:]); err != nil {
+			errorf("Error restoring metadata: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "systemd":
+		if err := runSystemd(flag.Args()[1:]); err != nil {
+			errorf("Error writing the systemd units: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "launchd":
+		if err := runLaunchd(flag.Args()[1:]); err != nil {
+			errorf("Error writing the launchd agent: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "import":
+		if err := runImport(config, flag.Args()[1:]); err != nil {
+			errorf("Error importing the state: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		errorf("Unknown command %q\n", flag.Arg(0))
+		os.Exit(2)
 	}
 
-	// Ensure destination directory exists
-	if info, err := os.Stat(config.Destination); err == nil && info.IsDir() {
-		createDirectory(config.Destination)
+	if *debugAddr != "" {
+		if err := startDiagnostics(*debugAddr); err != nil {
+			errorf("Error serving diagnostics: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Synchronize directories
-	err = SyncDirectories(config.Source, config.Destination, config.LogFile, config.Worker, config.SkipExtensions)
+	startTracing(config)
+	stats, err := SyncDirectories(config)
 	if err != nil {
-		fmt.Println("Error syncing directories:", err)
+		errorf("Error syncing directories: %v\n", err)
+	}
+	stopTracing(stats, err)
+	fmt.Fprintln(messageOutput(), stats.Summary())
+	if stats.Copied.Load() > 0 && !*quiet {
+		fmt.Fprint(messageOutput(), stats.Histogram())
+		fmt.Fprint(messageOutput(), stats.Top())
+	}
+	fmt.Fprintln(messageOutput(), stats.Line())
+	emitSummary(stats, err)
+	notifySystemd("STATUS=" + stats.Line())
+
+	// Schedulers such as launchd's KeepAlive and systemd's Restart rely on
+	// the status to tell a failed run
+	if err != nil || stats.Errors.Load() > 0 {
+		os.Exit(1)
 	}
 }
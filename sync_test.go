@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testConfig is the config of a sync into a new temporary directory, read
+// as config.json would be with the given options over the usual ones
+func testConfig(t *testing.T, options map[string]any) Config {
+	t.Helper()
+	dir := t.TempDir()
+	values := map[string]any{
+		"source":      "unused",
+		"destination": filepath.Join(dir, "dst"),
+		"logfile":     filepath.Join(dir, "log.txt"),
+		"worker":      2,
+	}
+	for key, value := range options {
+		values[key] = value
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestSyncFS(t *testing.T) {
+	mtime := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	src := fstest.MapFS{
+		"a.txt":       {Data: []byte("alpha"), ModTime: mtime},
+		"dir/b.txt":   {Data: []byte("beta"), ModTime: mtime},
+		"dir/c.pdf":   {Data: []byte("%PDF"), ModTime: mtime},
+		"empty":       {Mode: 0755 | os.ModeDir, ModTime: mtime},
+		"dir/sub/d.x": {Data: []byte("delta"), ModTime: mtime},
+	}
+	config := testConfig(t, map[string]any{
+		"skip_extensions":   []string{"pdf"},
+		"state_file":        filepath.Join(t.TempDir(), "state.json"),
+		"propagate_deletes": true,
+	})
+
+	stats, err := SyncFS(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied, errors := stats.Copied.Load(), stats.Errors.Load(); copied != 3 || errors != 0 {
+		t.Fatalf("first run copied %d files with %d errors, want 3 and 0", copied, errors)
+	}
+	for name, want := range map[string]string{"a.txt": "alpha", "dir/b.txt": "beta", "dir/sub/d.x": "delta"} {
+		path := filepath.Join(config.Destination, filepath.FromSlash(name))
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s was not given the time of the source", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(config.Destination, "dir", "c.pdf")); !os.IsNotExist(err) {
+		t.Errorf("the excluded dir/c.pdf was copied")
+	}
+	if info, err := os.Stat(filepath.Join(config.Destination, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("the empty folder was not created")
+	}
+
+	// Nothing changed
+	stats, err = SyncFS(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied := stats.Copied.Load(); copied != 0 {
+		t.Errorf("second run copied %d files, want 0", copied)
+	}
+
+	// A changed file is copied again, a deleted one is deleted
+	src["a.txt"] = &fstest.MapFile{Data: []byte("alpha 2"), ModTime: mtime.Add(time.Hour)}
+	delete(src, "dir/b.txt")
+	stats, err = SyncFS(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied := stats.Copied.Load(); copied != 1 {
+		t.Errorf("third run copied %d files, want 1", copied)
+	}
+	if data, _ := os.ReadFile(filepath.Join(config.Destination, "a.txt")); string(data) != "alpha 2" {
+		t.Errorf("a.txt = %q after the change, want %q", data, "alpha 2")
+	}
+	if _, err := os.Stat(filepath.Join(config.Destination, "dir", "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("dir/b.txt was not deleted with the source")
+	}
+}
+
+// equalStrings reports whether a and b hold the same strings in the same
+// order, nil being the same as empty
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// systemdUnit is the service running a sync, once per activation of the
+// timer. NotifyAccess lets the run report its progress to systemctl status.
+const systemdUnit = `[Unit]
+Description=GoSync %[1]s
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+WorkingDirectory=%[2]s
+ExecStart="%[3]s" -quiet
+NotifyAccess=main
+Nice=10
+IOSchedulingClass=idle
+`
+
+// systemdTimer starts the service on the schedule, catching up on the runs
+// missed while the machine was off
+const systemdTimer = `[Unit]
+Description=Run GoSync %[1]s on schedule
+
+[Timer]
+OnCalendar=%[2]s
+Persistent=true
+RandomizedDelaySec=5m
+
+[Install]
+WantedBy=timers.target
+`
+
+// runSystemd is the systemd command: it writes the service and timer units
+// running the sync of the config in the current folder on a schedule, to
+// stdout or with -install to the systemd folder, enabling the timer
+func runSystemd(args []string) error {
+	flags := flag.NewFlagSet("systemd", flag.ExitOnError)
+	name := flags.String("name", "gosync", "name of the units")
+	calendar := flags.String("on-calendar", "daily", "schedule of the timer, as in systemd.time(7)")
+	install := flags.Bool("install", false, "install the units and enable the timer")
+	user := flags.Bool("user", false, "with -install, install them for the current user instead of the system")
+	flags.Parse(args)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	service := fmt.Sprintf(systemdUnit, *name, dir, exe)
+	timer := fmt.Sprintf(systemdTimer, *name, *calendar)
+	if !*install {
+		fmt.Printf("# %s.service\n%s\n# %s.timer\n%s", *name, service, *name, timer)
+		return nil
+	}
+
+	unitDir, systemctl := "/etc/systemd/system", []string{}
+	if *user {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		unitDir, systemctl = filepath.Join(config, "systemd", "user"), []string{"--user"}
+	}
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return err
+	}
+	for file, content := range map[string]string{*name + ".service": service, *name + ".timer": timer} {
+		if err := os.WriteFile(filepath.Join(unitDir, file), []byte(content), 0644); err != nil {
+			return err
+		}
+		logf("Wrote %s\n", filepath.Join(unitDir, file))
+	}
+	for _, command := range [][]string{{"daemon-reload"}, {"enable", "--now", *name + ".timer"}} {
+		cmd := exec.Command("systemctl", append(systemctl, command...)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("systemctl %s: %v", strings.Join(command, " "), err)
+		}
+	}
+	logf("Enabled %s.timer: %s\n", *name, *calendar)
+	return nil
+}
+
+// notifySystemd sends state, such as "STATUS=Copying", to the service
+// manager when the run is a systemd service (sd_notify(3))
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		debugf("Cannot notify systemd: %v\n", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Filesystems accepted by the target_fs option. They all follow the naming
+// rules of Windows, which is what makes them unable to store some names
+// from Linux and macOS sources.
+const (
+	targetFAT32 = "fat32"
+	targetExFAT = "exfat"
+	targetNTFS  = "ntfs"
+)
+
+// Policies for the names target_fs cannot store
+const (
+	namePolicyReport = "report" // leave the file out, as an error
+	namePolicyRename = "rename" // store it under a sanitized name
+)
+
+// invalidNameChars cannot appear in a name on target_fs, besides the
+// control characters
+const invalidNameChars = `<>:"\|?*`
+
+// reservedNames are the device names of Windows, reserved with any
+// extension and in any case
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// invalidName returns why the slash-separated name cannot be stored on
+// target_fs
+func invalidName(name string) (string, bool) {
+	for _, part := range strings.Split(name, "/") {
+		if i := strings.IndexFunc(part, isInvalidNameRune); i >= 0 {
+			return fmt.Sprintf(tr("%q contains %q"), part, part[i]), true
+		}
+		if reservedNames[strings.ToUpper(reservedStem(part))] {
+			return fmt.Sprintf(tr("%q is a reserved name"), part), true
+		}
+		if strings.HasSuffix(part, ".") || strings.HasSuffix(part, " ") {
+			return fmt.Sprintf(tr("%q ends with a dot or a space"), part), true
+		}
+	}
+	return "", false
+}
+
+// sanitizeName makes every part of the slash-separated name storable on
+// target_fs: invalid characters, and a trailing dot or space, become "_",
+// and reserved names get a "_" before their extension. Sanitizing twice
+// changes nothing more, so sanitized names read back from the destination
+// map to themselves.
+func sanitizeName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		part = strings.Map(func(r rune) rune {
+			if isInvalidNameRune(r) {
+				return '_'
+			}
+			return r
+		}, part)
+		if stem := reservedStem(part); reservedNames[strings.ToUpper(stem)] {
+			part = stem + "_" + part[len(stem):]
+		}
+		if n := len(part); n > 0 && (part[n-1] == '.' || part[n-1] == ' ') && part != "." && part != ".." {
+			part = part[:n-1] + "_"
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "/")
+}
+
+func isInvalidNameRune(r rune) bool {
+	return r < 32 || strings.ContainsRune(invalidNameChars, r)
+}
+
+// reservedStem is the part of a name that makes it reserved: what comes
+// before the first dot, "CON" in "CON.txt"
+func reservedStem(part string) string {
+	if i := strings.IndexByte(part, '.'); i >= 0 {
+		return part[:i]
+	}
+	return part
+}
+
+// targetNames catches source names that end up as the same destination
+// file on target_fs, which ignores case, or once renamed
+type targetNames struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func newTargetNames() *targetNames {
+	return &targetNames{names: make(map[string]string)}
+}
+
+// claim records name, stored as dest, returning the other source name it
+// collides with
+func (t *targetNames) claim(name, dest string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	key := strings.ToLower(dest)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if other, ok := t.names[key]; ok && other != name {
+		return other, true
+	}
+	t.names[key] = name
+	return "", false
+}
+
+// renamingFS stores every name of the destination under the name given by
+// rename, with name_policy rename or long_paths shorten. Rename must map the
+// names it returns to themselves, as those are the names read back.
+type renamingFS struct {
+	DestFS
+	rename func(name string) string
+}
+
+// renamingLocalFS is a renamingFS of a destination on disk
+type renamingLocalFS struct {
+	renamingFS
+	local localFS
+}
+
+// withTargetNames wraps dst to sanitize or shorten its names when config
+// asks for it
+func withTargetNames(dst DestFS, config Config) DestFS {
+	sanitize := config.TargetFS != "" && config.NamePolicy == namePolicyRename
+	limit := newPathLimit(dst, config)
+	if config.LongPaths != longPathsShorten {
+		limit = nil
+	}
+
+	var rename func(string) string
+	switch {
+	case sanitize && limit != nil:
+		rename = func(name string) string { return limit.shorten(sanitizeName(name)) }
+	case sanitize:
+		rename = sanitizeName
+	case limit != nil:
+		rename = limit.shorten
+	default:
+		return dst
+	}
+	if local, ok := dst.(localFS); ok {
+		return renamingLocalFS{renamingFS{dst, rename}, local}
+	}
+	return renamingFS{dst, rename}
+}
+
+// storedDest is the destination of config as its files are stored: under
+// the names of target_fs and long_paths, but with every version kept by
+// append_only under its own name. Manifests, their verification and audits
+// all read it.
+func storedDest(config Config) DestFS {
+	return withTargetNames(openDestination(config), config)
+}
+
+// destName is the name under which dst stores the source entry name
+func destName(dst DestFS, name string) string {
+	switch f := dst.(type) {
+	case renamingFS:
+		return f.rename(name)
+	case renamingLocalFS:
+		return f.rename(name)
+	}
+	return name
+}
+
+// baseDest is the destination dst renames the names of, dst itself when it
+// renames none
+func baseDest(dst DestFS) DestFS {
+	for {
+		switch f := dst.(type) {
+		case renamingFS:
+			dst = f.DestFS
+		case renamingLocalFS:
+			dst = f.DestFS
+		default:
+			return dst
+		}
+	}
+}
+
+func (f renamingFS) Open(name string) (fs.File, error) {
+	return f.DestFS.Open(f.rename(name))
+}
+
+func (f renamingFS) Stat(name string) (fs.FileInfo, error) {
+	return f.DestFS.Stat(f.rename(name))
+}
+
+func (f renamingFS) MkdirAll(name string, perm fs.FileMode) error {
+	return f.DestFS.MkdirAll(f.rename(name), perm)
+}
+
+func (f renamingFS) Create(name string) (io.WriteCloser, error) {
+	return f.DestFS.Create(f.rename(name))
+}
+
+func (f renamingFS) Chmod(name string, mode fs.FileMode) error {
+	return f.DestFS.Chmod(f.rename(name), mode)
+}
+
+func (f renamingFS) Chtimes(name string, atime, mtime time.Time) error {
+	return f.DestFS.Chtimes(f.rename(name), atime, mtime)
+}
+
+func (f renamingFS) Remove(name string) error {
+	return f.DestFS.Remove(f.rename(name))
+}
+
+func (f renamingLocalFS) localPath(name string) string {
+	return f.local.localPath(f.rename(name))
+}
+
+// checkTargetName returns why the source entry name is not copied to
+// target_fs, reporting the names it renames
+func (s *syncer) checkTargetName(name string) (string, bool) {
+	if s.config.TargetFS == "" || name == "." {
+		return "", false
+	}
+	if reason, bad := invalidName(name); bad {
+		if s.config.NamePolicy == namePolicyReport {
+			return fmt.Sprintf(tr("%s on %s"), reason, s.config.TargetFS), true
+		}
+		debugf("Storing %s as %s: %s on %s\n", name, path.Base(destName(s.dst, name)), reason, s.config.TargetFS)
+	}
+	if other, ok := s.names.claim(name, destName(s.dst, name)); ok {
+		return fmt.Sprintf(tr("it would overwrite %s on %s"), other, s.config.TargetFS), true
+	}
+	return "", false
+}
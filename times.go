@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Granularities accepted by the preserve_times option
+const (
+	preserveNone  = "none"  // leave the times set by the copy
+	preserveMtime = "mtime" // modification time only (default)
+	preserveAtime = "atime" // modification and access times
+	preserveAll   = "all"   // modification, access and creation times
+)
+
+// preserveFileTimes applies the times of the source file described by info
+// to name in dst according to granularity
+func preserveFileTimes(info fs.FileInfo, dst DestFS, name, granularity string) error {
+	// Sources such as embed.FS have no times to preserve
+	if granularity == preserveNone || info.ModTime().IsZero() {
+		return nil
+	}
+
+	atime := time.Now()
+	if granularity == preserveAtime || granularity == preserveAll {
+		atime = accessTime(info)
+	}
+
+	// The creation time goes first: on macOS it is set through the
+	// modification time, which is then overwritten with the real one
+	if local, ok := dst.(localFS); ok && granularity == preserveAll {
+		if btime, ok := birthTime(info); ok {
+			if err := setBirthTime(local.localPath(name), btime); err != nil {
+				return err
+			}
+		}
+	}
+
+	return dst.Chtimes(name, atime, info.ModTime())
+}
+
+// modifyWindow is how far apart two modification times may be on dst and
+// still be the same, for destinations keeping coarser times than the source
+func modifyWindow(dst DestFS) time.Duration {
+	if f, ok := baseDest(dst).(interface{ modifyWindow() time.Duration }); ok {
+		return f.modifyWindow()
+	}
+	return 0
+}
+
+// sameModTime reports whether the modification times a of the source and b
+// of dst are the same
+func sameModTime(dst DestFS, a, b time.Time) bool {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return d <= modifyWindow(dst)
+}
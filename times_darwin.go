@@ -0,0 +1,30 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Unix())
+	}
+	return time.Now()
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Birthtimespec.Unix()), true
+	}
+	return time.Time{}, false
+}
+
+// setBirthTime sets the creation time of a file. APFS and HFS+ move the
+// creation time back whenever the modification time is set before it, so
+// the caller must restore the modification time afterwards.
+func setBirthTime(path string, btime time.Time) error {
+	return os.Chtimes(path, btime, btime)
+}
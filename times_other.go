@@ -0,0 +1,20 @@
+//go:build !windows && !darwin && !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+func accessTime(info os.FileInfo) time.Time {
+	return time.Now()
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func setBirthTime(path string, btime time.Time) error {
+	return nil
+}
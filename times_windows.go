@@ -0,0 +1,40 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTime(info os.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.LastAccessTime.Nanoseconds())
+	}
+	return time.Now()
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.CreationTime.Nanoseconds()), true
+	}
+	return time.Time{}, false
+}
+
+// setBirthTime sets the creation time of a file, leaving its other times untouched
+func setBirthTime(path string, btime time.Time) error {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	handle, err := syscall.CreateFile(pathPtr, syscall.FILE_WRITE_ATTRIBUTES, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+
+	ctime := syscall.NsecToFiletime(btime.UnixNano())
+	return syscall.SetFileTime(handle, &ctime, nil, nil)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTopFiles is the length of the lists of largest and slowest files
+const defaultTopFiles = 5
+
+// minSlowFileSize is the size from which a file can be among the slowest:
+// smaller ones take long because of the latency, not of the throughput
+const minSlowFileSize = 1 << 20
+
+// copiedFile is a copy kept in the lists of the summary
+type copiedFile struct {
+	path     string
+	bytes    int64
+	duration time.Duration
+}
+
+// speed is the throughput of the copy in bytes per second
+func (f copiedFile) speed() float64 {
+	return float64(f.bytes) / max(f.duration.Seconds(), 1e-9)
+}
+
+// topFiles keeps the n largest files copied and the n with the worst
+// throughput, so that a slow disk or folder shows up in the summary
+type topFiles struct {
+	mu      sync.Mutex
+	n       int
+	largest []copiedFile
+	slowest []copiedFile
+}
+
+func newTopFiles(n int) *topFiles {
+	return &topFiles{n: n}
+}
+
+// add records a copy, keeping only the n first of each list
+func (t *topFiles) add(file copiedFile) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.largest = keepFirst(t.largest, file, t.n, func(a, b copiedFile) bool { return a.bytes > b.bytes })
+	if file.bytes >= minSlowFileSize {
+		t.slowest = keepFirst(t.slowest, file, t.n, func(a, b copiedFile) bool { return a.speed() < b.speed() })
+	}
+}
+
+// keepFirst inserts file into the sorted list, dropping what goes past n
+func keepFirst(list []copiedFile, file copiedFile, n int, before func(a, b copiedFile) bool) []copiedFile {
+	i := sort.Search(len(list), func(i int) bool { return before(file, list[i]) })
+	if i >= n {
+		return list
+	}
+	list = append(list, copiedFile{})
+	copy(list[i+1:], list[i:])
+	list[i] = file
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// format renders both lists, leaving out the empty ones
+func (t *topFiles) format() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	if len(t.largest) > 0 {
+		fmt.Fprint(&b, tr("Largest files:\n"))
+		for _, file := range t.largest {
+			fmt.Fprintf(&b, "  %12s  %s\n", formatBytes(file.bytes), file.path)
+		}
+	}
+	if len(t.slowest) > 0 {
+		fmt.Fprint(&b, tr("Slowest files:\n"))
+		for _, file := range t.slowest {
+			fmt.Fprintf(&b, "  %12s  %s\n", formatBytes(int64(file.speed()))+"/s", file.path)
+		}
+	}
+	return b.String()
+}
+
+// Top returns the lists of the largest and slowest files copied
+func (s *Stats) Top() string {
+	return s.top.format()
+}
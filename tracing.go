@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceBatch is how many ended spans are sent to the collector at once
+const traceBatch = 512
+
+// traceQueue is how many batches wait for the collector before new ones
+// are dropped
+const traceQueue = 8
+
+// Codes of the status of an OTLP span
+const (
+	spanOK    = 1
+	spanError = 2
+)
+
+// tracer sends the spans of the run to an OpenTelemetry collector over
+// OTLP/HTTP in JSON. Every span belongs to the trace of the run, under its
+// root span.
+type tracer struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	traceID string
+	root    *span
+
+	mu      sync.Mutex
+	ended   []otlpSpan
+	queue   chan []otlpSpan // batches for the exporter
+	dropped int             // spans of the batches the queue had no room for
+	done    chan struct{}   // closed once the exporter is done
+}
+
+// span is an operation of the run being timed, nil without tracing
+type span struct {
+	t      *tracer
+	id     string
+	parent string
+	name   string
+	start  time.Time
+	mu     sync.Mutex
+	attrs  []otlpAttribute
+
+	// Time spent in the reads and writes of a copy
+	reading, writing time.Duration
+}
+
+// Messages of OTLP/HTTP in JSON, where integers and times are strings
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"`
+	Double *float64 `json:"doubleValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+}
+
+// tracing is the tracer of the run, nil unless trace_endpoint is set
+var tracing *tracer
+
+// startTracing starts the trace of the run when config, or the standard
+// OTEL_EXPORTER_OTLP_ variables, give a collector
+func startTracing(config Config) {
+	url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); url == "" && endpoint != "" {
+		url = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	if config.TraceEndpoint != "" {
+		url = strings.TrimSuffix(config.TraceEndpoint, "/") + "/v1/traces"
+	}
+	if url == "" {
+		return
+	}
+
+	headers := make(map[string]string)
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if key, value, ok := strings.Cut(header, "="); ok {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	// Through proxy, which applyProxy set in the environment
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	tracing = &tracer{url: url, headers: headers, client: client, traceID: randomID(16)}
+	tracing.queue = make(chan []otlpSpan, traceQueue)
+	tracing.done = make(chan struct{})
+	go tracing.exporter()
+	tracing.root = startSpan("sync", nil, attr("gosync.source", config.Source), attr("gosync.destination", config.Destination))
+}
+
+// stopTracing ends the root span with the outcome of the run and sends the
+// spans left
+func stopTracing(stats *Stats, err error) {
+	if tracing == nil {
+		return
+	}
+	tracing.root.set("gosync.copied", stats.Copied.Load())
+	tracing.root.set("gosync.skipped", stats.Skipped.Load())
+	tracing.root.set("gosync.errors", stats.Errors.Load())
+	tracing.root.set("gosync.bytes", stats.Bytes.Load())
+	tracing.root.end(err)
+
+	tracing.mu.Lock()
+	batch := tracing.ended
+	tracing.ended = nil
+	tracing.mu.Unlock()
+	tracing.queue <- batch
+	close(tracing.queue)
+	<-tracing.done
+	if tracing.dropped > 0 {
+		errorf("Warning: the trace collector was too slow, %d spans were dropped\n", tracing.dropped)
+	}
+}
+
+// startSpan starts the span name under parent, or under the root span of
+// the run when parent is nil
+func startSpan(name string, parent *span, attrs ...otlpAttribute) *span {
+	if tracing == nil {
+		return nil
+	}
+	if parent == nil {
+		parent = tracing.root
+	}
+	s := &span{t: tracing, id: randomID(8), name: name, start: time.Now(), attrs: attrs}
+	if parent != nil {
+		s.parent = parent.id
+	}
+	return s
+}
+
+// set records the attribute key of the span
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attr(key, value))
+}
+
+// end ends the span, failed when err is not nil, and queues a batch for
+// the collector once enough spans ended, dropping it when the queue is full
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if s.reading > 0 || s.writing > 0 {
+		s.set("gosync.read_seconds", s.reading.Seconds())
+		s.set("gosync.write_seconds", s.writing.Seconds())
+	}
+	s.mu.Lock()
+	ended := otlpSpan{
+		TraceID:      s.t.traceID,
+		SpanID:       s.id,
+		ParentSpanID: s.parent,
+		Name:         s.name,
+		Kind:         1, // internal
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:   s.attrs,
+		Status:       otlpStatus{Code: spanOK},
+	}
+	s.mu.Unlock()
+	if err != nil {
+		ended.Status = otlpStatus{Code: spanError, Message: err.Error()}
+	}
+
+	t := s.t
+	t.mu.Lock()
+	t.ended = append(t.ended, ended)
+	var batch []otlpSpan
+	if len(t.ended) >= traceBatch {
+		batch, t.ended = t.ended, nil
+		select {
+		case t.queue <- batch:
+		default:
+			t.dropped += len(batch)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// reader times the reads of r in the span, or returns it as is when nil
+func (s *span) reader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &timedReader{r, &s.reading}
+}
+
+// writer times the writes to w in the span, or returns it as is when nil
+func (s *span) writer(w io.Writer) io.Writer {
+	if s == nil {
+		return w
+	}
+	return &timedWriter{w, &s.writing}
+}
+
+type timedReader struct {
+	r       io.Reader
+	elapsed *time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.r.Read(p)
+	*r.elapsed += time.Since(start)
+	return n, err
+}
+
+type timedWriter struct {
+	w       io.Writer
+	elapsed *time.Duration
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.w.Write(p)
+	*w.elapsed += time.Since(start)
+	return n, err
+}
+
+// exporter sends the queued batches to the collector, one at a time
+func (t *tracer) exporter() {
+	defer close(t.done)
+	for batch := range t.queue {
+		t.export(batch)
+	}
+}
+
+// export posts batch to the collector. A collector that is down costs the
+// trace, never the run.
+func (t *tracer) export(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	name := "gosync"
+	request := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource":   map[string]any{"attributes": []otlpAttribute{attr("service.name", name)}},
+			"scopeSpans": []any{map[string]any{"scope": map[string]string{"name": name}, "spans": batch}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		errorf("Error exporting traces: %v\n", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		errorf("Error exporting traces: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		errorf("Error exporting traces: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		errorf("Error exporting traces: %s answered %s\n", t.url, resp.Status)
+	}
+}
+
+// attr is the attribute key of a span with value
+func attr(key string, value any) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.String = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.Int = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.Int = &s
+	case float64:
+		v.Double = &value
+	case bool:
+		v.Bool = &value
+	default:
+		s := fmt.Sprint(value)
+		v.String = &s
+	}
+	return otlpAttribute{key, v}
+}
+
+// randomID is an id of n random bytes in hex, as trace and span ids are
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
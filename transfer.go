@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sampling of the aggregate throughput: every transferSampleInterval the
+// bytes copied since the last sample are folded into a moving average
+const (
+	transferSampleInterval = time.Second
+	transferSmoothing      = 0.2
+)
+
+// transferTracker follows the bytes copied by all workers against the total
+// computed by the pre-scan, to estimate the remaining time of the whole job
+type transferTracker struct {
+	total int64
+	done  atomic.Int64
+
+	mu      sync.Mutex
+	rate    float64   // moving average, in bytes per second
+	history []float64 // throughput of each sample, in bytes per second
+	stop    chan struct{}
+}
+
+func newTransferTracker(total int64) *transferTracker {
+	t := &transferTracker{total: total, stop: make(chan struct{})}
+	go t.sample()
+	return t
+}
+
+func (t *transferTracker) sample() {
+	ticker := time.NewTicker(transferSampleInterval)
+	defer ticker.Stop()
+
+	last := int64(0)
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			done := t.done.Load()
+			instant := float64(done-last) / transferSampleInterval.Seconds()
+			last = done
+
+			t.mu.Lock()
+			t.history = append(t.history, instant)
+			if t.rate == 0 {
+				t.rate = instant
+			} else {
+				t.rate = transferSmoothing*instant + (1-transferSmoothing)*t.rate
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Add records n bytes copied by any worker
+func (t *transferTracker) Add(n int) {
+	t.done.Add(int64(n))
+}
+
+// Rate returns the moving average of the aggregate throughput in bytes per second
+func (t *transferTracker) Rate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rate
+}
+
+// History returns the throughput of every sample so far, oldest first
+func (t *transferTracker) History() []float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]float64(nil), t.history...)
+}
+
+// ETA returns the estimated remaining time of the job, or false while
+// there is no throughput to estimate it from
+func (t *transferTracker) ETA() (time.Duration, bool) {
+	rate := t.Rate()
+	if rate <= 0 {
+		return 0, false
+	}
+	remaining := max(t.total-t.done.Load(), 0)
+	return time.Duration(float64(remaining) / rate * float64(time.Second)), true
+}
+
+// Stop ends the sampling
+func (t *transferTracker) Stop() {
+	close(t.stop)
+}
+
+// formatETA formats a remaining time, or "--" when it is unknown
+func formatETA(eta time.Duration, ok bool) string {
+	if !ok {
+		return "--"
+	}
+	return eta.Round(time.Second).String()
+}
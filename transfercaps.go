@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var maxDuration = flag.Duration("max-duration", 0, "stop starting copies this long after the start of the run, leaving the rest for -resume")
+
+// transferCaps limits what a run copies with max_transfer, max_files and
+// max_duration, so that a metered job copies a bounded share of the
+// backlog per run, or a job fits a maintenance window, and leaves the rest
+// for the next runs
+type transferCaps struct {
+	mu       sync.Mutex
+	maxBytes int64 // 0 for no limit
+	maxFiles int   // 0 for no limit
+	duration time.Duration
+	deadline time.Time // zero for no limit
+	bytes    int64     // started so far
+	files    int
+	left     int // files not copied for the caps
+	leftSize int64
+	unsent   int // entries not even checked once past the deadline
+}
+
+// newTransferCaps returns the caps of config for a run started at start,
+// nil without any
+func newTransferCaps(config Config, start time.Time) *transferCaps {
+	if config.MaxTransfer == "" && config.MaxFiles == 0 && config.MaxDuration == "" {
+		return nil
+	}
+	c := &transferCaps{maxFiles: config.MaxFiles}
+	c.maxBytes, _ = parseSize(config.MaxTransfer)
+	if config.MaxDuration != "" {
+		c.duration, _ = time.ParseDuration(config.MaxDuration)
+		c.deadline = start.Add(c.duration)
+	}
+	return c
+}
+
+// expired reports whether the run is past max_duration
+func (c *transferCaps) expired() bool {
+	return c != nil && !c.deadline.IsZero() && time.Now().After(c.deadline)
+}
+
+// stop records that the run stopped before checking unsent entries
+func (c *transferCaps) stop(unsent int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsent += unsent
+}
+
+// take counts the copy of a file of size against the caps, returning why
+// it must wait for the next run when it does not fit. A file larger than
+// max_transfer is still copied first thing in a run, or it never would be.
+func (c *transferCaps) take(size int64) (string, bool) {
+	if c == nil {
+		return "", true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var reason string
+	switch {
+	case c.expired():
+		reason = fmt.Sprintf(tr("max_duration %s reached"), c.duration)
+	case c.maxFiles > 0 && c.files >= c.maxFiles:
+		reason = fmt.Sprintf(tr("max_files %d reached"), c.maxFiles)
+	case c.maxBytes > 0 && c.bytes > 0 && c.bytes+size > c.maxBytes:
+		reason = fmt.Sprintf(tr("over max_transfer %s"), formatBytes(c.maxBytes))
+	default:
+		c.files++
+		c.bytes += size
+		return "", true
+	}
+	c.left++
+	c.leftSize += size
+	return reason, false
+}
+
+// cutShort reports whether the caps left files for the next run
+func (c *transferCaps) cutShort() bool {
+	return c != nil && (c.left > 0 || c.unsent > 0)
+}
+
+// report tells how much the caps left for the next run
+func (c *transferCaps) report() {
+	if c == nil {
+		return
+	}
+	if c.left > 0 {
+		logf("Transfer caps reached: %d files (%s) left for the next run\n", c.left, formatBytes(c.leftSize))
+	}
+	if c.unsent > 0 {
+		logf("Stopped after max_duration %s: %d entries not checked, left for -resume\n", c.duration, c.unsent)
+	}
+}
+
+// parseSize parses a size such as "500M" or "10G" into bytes; without a
+// suffix the size is in bytes
+func parseSize(s string) (int64, error) {
+	unit := 1.0
+	if i := len(s) - 1; i >= 0 {
+		switch s[i] {
+		case 'b', 'B':
+			s = s[:i]
+		case 'k', 'K':
+			unit, s = 1<<10, s[:i]
+		case 'm', 'M':
+			unit, s = 1<<20, s[:i]
+		case 'g', 'G':
+			unit, s = 1<<30, s[:i]
+		case 't', 'T':
+			unit, s = 1<<40, s[:i]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * unit), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferCapsTake(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		sizes  []int64
+		want   []bool
+	}{
+		{"under max_transfer", Config{MaxTransfer: "1K"}, []int64{400, 400, 400, 100}, []bool{true, true, false, true}},
+		{"one file over max_transfer", Config{MaxTransfer: "1K"}, []int64{3000}, []bool{true}},
+		{"over max_transfer after a first file", Config{MaxTransfer: "1K"}, []int64{3000, 10}, []bool{true, false}},
+		{"large file after others", Config{MaxTransfer: "1K"}, []int64{10, 3000}, []bool{true, false}},
+		{"max_files", Config{MaxFiles: 2}, []int64{1, 1, 1}, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps := newTransferCaps(tt.config, time.Now())
+			left := 0
+			for i, size := range tt.sizes {
+				if _, ok := caps.take(size); ok != tt.want[i] {
+					t.Errorf("take(%d) of file %d = %v, want %v", size, i, ok, tt.want[i])
+				} else if !ok {
+					left++
+				}
+			}
+			if caps.cutShort() != (left > 0) {
+				t.Errorf("cutShort() = %v with %d files left", caps.cutShort(), left)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// xxHash64 primes
+const (
+	xxhPrime1 uint64 = 0x9E3779B185EBCA87
+	xxhPrime2 uint64 = 0xC2B2AE3D27D4EB4F
+	xxhPrime3 uint64 = 0x165667B19E3779F9
+	xxhPrime4 uint64 = 0x85EBCA77C2B2AE63
+	xxhPrime5 uint64 = 0x27D4EB2F165667C5
+)
+
+// xxh64 is the non-cryptographic xxHash64 with a zero seed, an order of
+// magnitude faster than SHA-256 and enough to tell copies apart. Sums are
+// big-endian, like the canonical xxhsum output.
+type xxh64 struct {
+	v      [4]uint64
+	buf    [32]byte
+	buffed int
+	total  uint64
+}
+
+func newXXH64() hash.Hash {
+	h := &xxh64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	// The initial lanes wrap around, which constant arithmetic does not allow
+	p1, p2 := xxhPrime1, xxhPrime2
+	h.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	h.buffed = 0
+	h.total = 0
+}
+
+func (h *xxh64) Size() int      { return 8 }
+func (h *xxh64) BlockSize() int { return 32 }
+
+func (h *xxh64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+
+	if h.buffed > 0 {
+		copied := copy(h.buf[h.buffed:], p)
+		h.buffed += copied
+		p = p[copied:]
+		if h.buffed < len(h.buf) {
+			return n, nil
+		}
+		h.stripe(h.buf[:])
+		h.buffed = 0
+	}
+	for len(p) >= 32 {
+		h.stripe(p[:32])
+		p = p[32:]
+	}
+	h.buffed = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *xxh64) stripe(p []byte) {
+	for i := range h.v {
+		h.v[i] = xxhRound(h.v[i], binary.LittleEndian.Uint64(p[i*8:]))
+	}
+}
+
+func (h *xxh64) Sum(b []byte) []byte {
+	var acc uint64
+	if h.total >= 32 {
+		v := h.v
+		acc = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, lane := range v {
+			acc = (acc^xxhRound(0, lane))*xxhPrime1 + xxhPrime4
+		}
+	} else {
+		acc = xxhPrime5
+	}
+	acc += h.total
+
+	p := h.buf[:h.buffed]
+	for ; len(p) >= 8; p = p[8:] {
+		acc ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		acc = bits.RotateLeft64(acc, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		acc = bits.RotateLeft64(acc, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, c := range p {
+		acc ^= uint64(c) * xxhPrime5
+		acc = bits.RotateLeft64(acc, 11) * xxhPrime1
+	}
+
+	acc ^= acc >> 33
+	acc *= xxhPrime2
+	acc ^= acc >> 29
+	acc *= xxhPrime3
+	acc ^= acc >> 32
+	return binary.BigEndian.AppendUint64(b, acc)
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	return bits.RotateLeft64(acc, 31) * xxhPrime1
+}